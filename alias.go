@@ -0,0 +1,167 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go/scanner"
+	"go/token"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// splitTopLevel splits src at the sep tokens outside of brackets, so that
+// `f(a, b), "x,y"` split at commas has the parts `f(a, b)` and `"x,y"`
+func splitTopLevel(src string, sep token.Token) (parts []string) {
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, []byte(src), nil, 0)
+
+	depth, begin := 0, 0
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		switch tok {
+		case token.LPAREN, token.LBRACK, token.LBRACE:
+			depth++
+		case token.RPAREN, token.RBRACK, token.RBRACE:
+			depth--
+		case sep:
+			// semicolons inserted at the end of src are not separators
+			if depth == 0 && (tok != token.SEMICOLON || lit == ";") {
+				offset := file.Offset(pos)
+				parts = append(parts, src[begin:offset])
+				begin = offset + 1
+			}
+		}
+	}
+	return append(parts, src[begin:])
+}
+
+// expandAlias replaces $* in expansion with args, and $1 to $9 with the
+// parameters in args
+func expandAlias(expansion, args string) string {
+	params := []string{}
+	if args != "" {
+		for _, param := range splitTopLevel(args, token.COMMA) {
+			params = append(params, strings.TrimSpace(param))
+		}
+	}
+	return regexp.MustCompile(`\$[*1-9]`).ReplaceAllStringFunc(expansion, func(v string) string {
+		if v == "$*" {
+			return args
+		}
+		n, _ := strconv.Atoi(v[1:])
+		if n > len(params) {
+			return ""
+		}
+		return params[n-1]
+	})
+}
+
+// assignRe matches what follows an identifier being assigned to or
+// incremented, which keeps `pp = 1` from being taken as the alias pp
+var assignRe = regexp.MustCompile(`^(:?=([^=]|$)|(<<|>>|&\^|[-+*/%&|^])=|\+\+|--|,)`)
+
+// expandLine expands aliases at the start of each statement of line, and
+// again in their expansions, up to a fixed depth
+func expandLine(line string, depth int) string {
+	if depth > 10 {
+		return line
+	}
+
+	parts := splitTopLevel(line, token.SEMICOLON)
+	for pos, part := range parts {
+		stmt := strings.TrimSpace(part)
+		name, args := stmt, ""
+		if p := strings.IndexAny(stmt, " \t"); p != -1 {
+			name, args = stmt[:p], strings.TrimSpace(stmt[p+1:])
+		}
+		if expansion, ok := conf.Aliases[name]; ok && !assignRe.MatchString(args) {
+			parts[pos] = expandLine(expandAlias(expansion, args), depth+1)
+		}
+	}
+	return strings.Join(parts, ";")
+}
+
+func execAlias(w *Workspace, line string) string {
+	sps := []string{}
+	for _, sp := range strings.Split(line, "\n") {
+		sps = append(sps, expandLine(sp, 0))
+	}
+	return strings.Join(sps, "\n")
+}
+
+func aliasNames() []string {
+	names := []string{}
+	for name := range conf.Aliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func cmdAlias(w *Workspace, args string) error {
+	name, expansion := args, ""
+	if p := strings.IndexAny(args, " \t"); p != -1 {
+		name, expansion = args[:p], strings.TrimSpace(args[p+1:])
+	}
+
+	if name == "" {
+		for _, name := range aliasNames() {
+			fmt.Printf("%s\t%s\n", name, conf.Aliases[name])
+		}
+		return nil
+	}
+	if expansion == "" {
+		v, ok := conf.Aliases[name]
+		if !ok {
+			return errors.New("no alias " + name)
+		}
+		fmt.Printf("%s\t%s\n", name, v)
+		return nil
+	}
+
+	return changeAlias(name, expansion)
+}
+
+func cmdUnalias(w *Workspace, args string) error {
+	if _, ok := conf.Aliases[args]; !ok {
+		return errors.New("no alias " + args)
+	}
+	return changeAlias(args, "")
+}
+
+// changeAlias sets name to expansion, or removes it when expansion is empty,
+// as a change of the config command so that config save keeps it
+func changeAlias(name, expansion string) error {
+	c, err := globalConfig()
+	if err != nil {
+		return err
+	}
+	aliases := map[string]string{}
+	for k, v := range c.Aliases {
+		aliases[k] = v
+	}
+	if expansion == "" {
+		delete(aliases, name)
+		delete(conf.Aliases, name)
+	} else {
+		aliases[name] = expansion
+		if conf.Aliases == nil {
+			conf.Aliases = map[string]string{}
+		}
+		conf.Aliases[name] = expansion
+	}
+	raw, err := json.Marshal(aliases)
+	if err != nil {
+		return err
+	}
+	configChanges["aliases"] = raw
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestSplitTopLevel(t *testing.T) {
+	for _, c := range []struct {
+		src   string
+		sep   token.Token
+		parts []string
+	}{
+		{"", token.COMMA, []string{""}},
+		{"a", token.COMMA, []string{"a"}},
+		{"a, b", token.COMMA, []string{"a", " b"}},
+		{`f(a, b), "x,y"`, token.COMMA, []string{"f(a, b)", ` "x,y"`}},
+		{"[]int{1, 2}, m[k]", token.COMMA, []string{"[]int{1, 2}", " m[k]"}},
+		{"'a', ','", token.COMMA, []string{"'a'", " ','"}},
+		{"x := 1; y := 2", token.SEMICOLON, []string{"x := 1", " y := 2"}},
+		{"x := 1\ny := 2", token.SEMICOLON, []string{"x := 1\ny := 2"}},
+		{"func() { a; b }(); c", token.SEMICOLON, []string{"func() { a; b }()", " c"}},
+		{"s := `;`", token.SEMICOLON, []string{"s := `;`"}},
+	} {
+		if parts := splitTopLevel(c.src, c.sep); !reflect.DeepEqual(parts, c.parts) {
+			t.Errorf("splitTopLevel(%q, %s) = %q; want %q", c.src, c.sep, parts, c.parts)
+		}
+	}
+}
+
+func TestExpandAlias(t *testing.T) {
+	for _, c := range []struct {
+		expansion, args string
+		expanded        string
+	}{
+		{"println($*)", "", "println()"},
+		{"println($*)", "a, b", "println(a, b)"},
+		{`fmt.Printf("%#v\n", $1)`, "x", `fmt.Printf("%#v\n", x)`},
+		{"$2 = $1", "f(a, b),  c", "c = f(a, b)"},
+		{"f($1, $3)", "a", "f(a, )"},
+		{"$1$1", `"$2"`, `"$2""$2"`},
+	} {
+		if expanded := expandAlias(c.expansion, c.args); expanded != c.expanded {
+			t.Errorf("expandAlias(%q, %q) = %q; want %q", c.expansion, c.args, expanded, c.expanded)
+		}
+	}
+}
+
+func TestAliasSaved(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gop")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	savedHome, savedConf, savedChanges := home, conf, configChanges
+	defer func() { home, conf, configChanges = savedHome, savedConf, savedChanges }()
+	home, configChanges = dir, map[string]json.RawMessage{}
+	conf.Aliases = map[string]string{"echo": "println($*)", "proj": "f($*)"}
+
+	if err := cmdAlias(nil, "pp fmt.Printf(\"%v\\n\", $*)"); err != nil {
+		t.Fatal(err)
+	}
+	if err := cmdUnalias(nil, "echo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := saveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	bs, err := ioutil.ReadFile(filepath.Join(dir, "config.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := Config{}
+	if err := json.Unmarshal(bs, &saved); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"pp": `fmt.Printf("%v\n", $*)`}
+	if !reflect.DeepEqual(saved.Aliases, want) {
+		t.Errorf("saved aliases %q; want %q", saved.Aliases, want)
+	}
+	if conf.Aliases["proj"] != "f($*)" || conf.Aliases["pp"] == "" || conf.Aliases["echo"] != "" {
+		t.Errorf("aliases in use %q", conf.Aliases)
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// buildEnv are the environment variables build sets for go build
+var buildEnv = []string{"CGO_ENABLED", "GOEXPERIMENT", "GOAMD64", "GOOS", "GOARCH"}
+
+// buildSettings are the go build flags and environment every compile of a
+// session uses. Their slices are replaced, never changed in place.
+type buildSettings struct {
+	tags, gcflags, ldflags string
+	race, trimpath         bool
+	// xs are the importpath.name=value of -X flags, added to ldflags
+	xs  []string
+	env []string
+}
+
+// common returns the flags that are the same in words and in flags
+func (b buildSettings) common() []string {
+	flags := []string{}
+	if b.tags != "" {
+		flags = append(flags, "-tags", b.tags)
+	}
+	if b.race {
+		flags = append(flags, "-race")
+	}
+	if b.trimpath {
+		flags = append(flags, "-trimpath")
+	}
+	if b.gcflags != "" {
+		flags = append(flags, "-gcflags", b.gcflags)
+	}
+	return flags
+}
+
+// words returns the settings as the words of the build command that makes
+// them
+func (b buildSettings) words() []string {
+	words := append(append([]string(nil), b.env...), b.common()...)
+	if b.ldflags != "" {
+		words = append(words, "-ldflags", b.ldflags)
+	}
+	for _, x := range b.xs {
+		words = append(words, "-X", x)
+	}
+	return words
+}
+
+// flags returns the flags of go build
+func (b buildSettings) flags() []string {
+	flags := b.common()
+	ldflags := b.ldflags
+	for _, x := range b.xs {
+		ldflags = strings.TrimSpace(ldflags + " -X " + quoteWord(x))
+	}
+	if ldflags != "" {
+		flags = append(flags, "-ldflags", ldflags)
+	}
+	return flags
+}
+
+// cross tells whether b builds the program for another system than gop's,
+// where it only compiles and does not run
+func (b buildSettings) cross() bool {
+	for _, kv := range b.env {
+		key, value := envKey(kv), strings.TrimPrefix(kv, envKey(kv)+"=")
+		if key == "GOOS" && value != runtime.GOOS || key == "GOARCH" && value != runtime.GOARCH {
+			return true
+		}
+	}
+	return false
+}
+
+func (b buildSettings) String() string {
+	return quoteWords(b.words())
+}
+
+func quoteWords(words []string) string {
+	quoted := []string{}
+	for _, word := range words {
+		quoted = append(quoted, quoteWord(word))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// quoteWord quotes s for splitWords, and for the flags go build splits
+func quoteWord(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\") {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// apply changes b as the words of the build command say
+func (b *buildSettings) apply(words []word) error {
+	for i := 0; i < len(words); i++ {
+		name, value, hasValue := words[i].text, "", false
+		if p := strings.Index(name, "="); p != -1 {
+			name, value, hasValue = name[:p], name[p+1:], true
+		}
+		if strings.HasPrefix(name, "--") {
+			name = name[1:]
+		}
+
+		switch name {
+		case "-race", "-trimpath":
+			on := true
+			if hasValue {
+				var err error
+				if on, err = strconv.ParseBool(value); err != nil {
+					return fmt.Errorf("%s: %s not bool", name, value)
+				}
+			}
+			if name == "-race" {
+				b.race = on
+			} else {
+				b.trimpath = on
+			}
+		case "-tags", "-gcflags", "-ldflags", "-X":
+			if !hasValue {
+				if i++; i == len(words) {
+					return errors.New("flag needs an argument: " + name)
+				}
+				value = words[i].text
+			}
+			switch name {
+			case "-tags":
+				b.tags = value
+			case "-gcflags":
+				b.gcflags = value
+			case "-ldflags":
+				b.ldflags = value
+			default:
+				if !strings.Contains(value, "=") {
+					return errors.New("-X takes importpath.name=value: " + value)
+				}
+				b.xs = setEnv(b.xs, value)
+			}
+		default:
+			known := false
+			for _, key := range buildEnv {
+				known = known || key == name
+			}
+			if !known || !hasValue {
+				return errors.New("unknown build setting: " + words[i].text)
+			}
+			if value == "" {
+				b.env = removeEnv(b.env, name)
+			} else {
+				b.env = setEnv(b.env, words[i].text)
+			}
+		}
+	}
+	return nil
+}
+
+// cmdBuild shows the build settings, changes them, or resets them
+func cmdBuild(w *Workspace, args string) error {
+	switch args {
+	case "":
+		fmt.Println(strings.TrimSpace(quoteWords(w.build.env) + " go build " + quoteWords(w.build.flags())))
+		return nil
+	case "reset":
+		w.build = buildSettings{}
+		return nil
+	}
+	words, err := splitWords(args)
+	if err != nil {
+		return err
+	}
+	b := w.build
+	if err = b.apply(words); err != nil {
+		return err
+	}
+	w.build = b
+	return nil
+}
+
+// lineEntries returns the entry each line of the program belongs to, such
+// as c3, or "" for a line of none
+func (w *Workspace) lineEntries() []string {
+	entries := []string{}
+	label := regexp.MustCompile(`^([dpc]\d+):\t`)
+	entry := ""
+	for _, line := range strings.Split(w.source(true, false, false), "\n") {
+		switch {
+		case label.MatchString(line):
+			entry = label.FindStringSubmatch(line)[1]
+		case line == "", line == "\tfunc main() {", line == "\t}" && strings.HasPrefix(entry, "c"):
+			entry = ""
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+var sourceLine = regexp.MustCompile(`/(gop|gop_session_test)\.go:(\d+)`)
+
+// entryWriter adds the entry to the lines of the program named in what is
+// written through it, as race reports do, so that gop.go:12 reads
+// gop.go:12 (c3). Text is held back only from a '/' that may start such a
+// name, up to the end of its line.
+type entryWriter struct {
+	w       io.Writer
+	entries []string
+	held    []byte
+}
+
+func (ew *entryWriter) Write(p []byte) (int, error) {
+	buf := append(ew.held, p...)
+	cut := bytes.LastIndexByte(buf, '\n') + 1
+	if i := bytes.LastIndexByte(buf[cut:], '/'); i != -1 {
+		cut += i
+	} else {
+		cut = len(buf)
+	}
+	ew.held = append([]byte(nil), buf[cut:]...)
+	_, err := ew.w.Write(ew.mark(buf[:cut]))
+	return len(p), err
+}
+
+// Flush writes the text held back
+func (ew *entryWriter) Flush() {
+	ew.w.Write(ew.mark(ew.held))
+	ew.held = nil
+}
+
+func (ew *entryWriter) mark(text []byte) []byte {
+	return sourceLine.ReplaceAllFunc(text, func(name []byte) []byte {
+		n, _ := strconv.Atoi(string(sourceLine.FindSubmatch(name)[2]))
+		if n < 1 || n > len(ew.entries) || ew.entries[n-1] == "" {
+			return name
+		}
+		return []byte(string(name) + " (" + ew.entries[n-1] + ")")
+	})
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"go/token"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestBuildApply(t *testing.T) {
+	for _, c := range []struct {
+		line  string
+		words string
+		flags string
+		err   bool
+	}{
+		{"", "", "", false},
+		{"-race -trimpath", "-race -trimpath", "-race -trimpath", false},
+		{"-race -race=false", "", "", false},
+		{"-tags 'a b' --gcflags=-N", "-tags 'a b' -gcflags -N", "-tags 'a b' -gcflags -N", false},
+		{"-ldflags=-s -X main.v=1 -X 'main.w=a b'", "-ldflags -s -X main.v=1 -X 'main.w=a b'", "-ldflags '-s -X main.v=1 -X '\\''main.w=a b'\\'''", false},
+		{"-X main.v=1 -X main.v=2", "-X main.v=2", "-ldflags '-X main.v=2'", false},
+		{"CGO_ENABLED=0 GOOS=windows", "CGO_ENABLED=0 GOOS=windows", "", false},
+		{"CGO_ENABLED=0 CGO_ENABLED=", "", "", false},
+		{"-race=maybe", "", "", true},
+		{"-tags", "", "", true},
+		{"-X main.v", "", "", true},
+		{"GOPATH=/x", "", "", true},
+		{"CGO_ENABLED", "", "", true},
+		{"-v", "", "", true},
+	} {
+		words, err := splitWords(c.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b := buildSettings{}
+		err = b.apply(words)
+		if (err != nil) != c.err {
+			t.Errorf("build %s: error %v", c.line, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if b.String() != c.words || quoteWords(b.flags()) != c.flags {
+			t.Errorf("build %s = %s, flags %s; want %s, %s", c.line, b, quoteWords(b.flags()), c.words, c.flags)
+		}
+	}
+}
+
+func TestBuildCross(t *testing.T) {
+	for _, c := range []struct {
+		env   []string
+		cross bool
+	}{
+		{nil, false},
+		{[]string{"CGO_ENABLED=0"}, false},
+		{[]string{"GOOS=" + runtime.GOOS, "GOARCH=" + runtime.GOARCH}, false},
+		{[]string{"GOOS=plan9"}, true},
+		{[]string{"GOARCH=mips"}, true},
+	} {
+		if cross := (buildSettings{env: c.env}).cross(); cross != c.cross {
+			t.Errorf("cross with %q = %v; want %v", c.env, cross, c.cross)
+		}
+	}
+}
+
+func TestLineEntries(t *testing.T) {
+	w := &Workspace{files: token.NewFileSet()}
+	for _, line := range []string{`import "fmt"`, "func f() {\n\tfmt.Println()\n}", "x := 1", "if x > 0 {\n\tf()\n}"} {
+		if _, err := parseGo4import(w, line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want := map[string]string{
+		"package main":    "",
+		`import "fmt"`:    "p0",
+		"func f() {":      "d0",
+		"\tfmt.Println()": "d0",
+		"func main() {":   "",
+		"\tx := 1":        "c0",
+		"\tif x > 0 {":    "c1",
+		"\t\tf()":         "c1",
+		"\t}":             "c1",
+	}
+	entries := w.lineEntries()
+	lines := strings.Split(w.source(false, false, false), "\n")
+	if len(entries) != len(lines) {
+		t.Fatalf("%d entries for %d lines", len(entries), len(lines))
+	}
+	for n, line := range lines {
+		if entry, ok := want[line]; ok && entries[n] != entry {
+			t.Errorf("line %d %q is of %q; want %q", n+1, line, entries[n], entry)
+		}
+	}
+}
+
+func TestEntryWriter(t *testing.T) {
+	entries := []string{"", "", "p0", "", "c0", "c1"}
+	text := "WARNING: DATA RACE\n  main.main()\n      /tmp/gop1/gop.go:5 +0x1d\n" +
+		"  /tmp/gop1/gop.go:6\n  /tmp/gop1/gop.go:1 x/gop.go:3 /tmp/gop1/gop.go:99\n" +
+		"  /a/gop_session_test.go:3\n  /tmp/gop1/gop.go:6"
+	want := "WARNING: DATA RACE\n  main.main()\n      /tmp/gop1/gop.go:5 (c0) +0x1d\n" +
+		"  /tmp/gop1/gop.go:6 (c1)\n  /tmp/gop1/gop.go:1 x/gop.go:3 (p0) /tmp/gop1/gop.go:99\n" +
+		"  /a/gop_session_test.go:3 (p0)\n  /tmp/gop1/gop.go:6 (c1)"
+	for size := 1; size <= len(text); size++ {
+		out := new(bytes.Buffer)
+		ew := &entryWriter{w: out, entries: entries}
+		for i := 0; i < len(text); i += size {
+			end := i + size
+			if end > len(text) {
+				end = len(text)
+			}
+			if n, err := ew.Write([]byte(text[i:end])); n != end-i || err != nil {
+				t.Fatalf("Write = %d, %v", n, err)
+			}
+		}
+		ew.Flush()
+		if out.String() != want {
+			t.Fatalf("written in pieces of %d:\n%s\nwant:\n%s", size, out, want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// checkpoint is a named copy of the workspace. A fork is a checkpoint that
+// follows the changes made to the workspace while it is the current one.
+type checkpoint struct {
+	*snapshot
+	created time.Time
+	fork    bool
+}
+
+// saveCheckpoint saves the workspace as name, replacing any checkpoint of
+// that name
+func (w *Workspace) saveCheckpoint(name string, fork bool) error {
+	if name == "" {
+		return errors.New("no checkpoint name")
+	}
+	if w.checkpoints == nil {
+		w.checkpoints = map[string]*checkpoint{}
+	}
+	w.checkpoints[name] = &checkpoint{snapshot: w.snapshot(), created: time.Now(), fork: fork}
+	w.fork = ""
+	if fork {
+		w.fork = name
+	}
+	return nil
+}
+
+// follow updates the current fork to the state of the workspace
+func (w *Workspace) follow(s *snapshot) {
+	if c := w.checkpoints[w.fork]; c != nil {
+		c.snapshot = s
+	}
+}
+
+func listCheckpoints(w *Workspace) {
+	names := []string{}
+	for name := range w.checkpoints {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c := w.checkpoints[name]
+		mark, kind := " ", "checkpoint"
+		if name == w.fork {
+			mark = "*"
+		}
+		if c.fork {
+			kind = "fork"
+		}
+		fmt.Printf("%s %s\t%s\td%d p%d c%d\t%s\n", mark, name, kind,
+			len(c.defs), len(c.pkgs)+len(c.pkgsNotimport), len(c.codes),
+			c.created.Format("2006-01-02 15:04:05"))
+	}
+}
+
+func cmdCheckpoint(w *Workspace, args string) error {
+	if args == "" {
+		listCheckpoints(w)
+		return nil
+	}
+	return w.saveCheckpoint(args, false)
+}
+
+func cmdFork(w *Workspace, args string) error {
+	return w.saveCheckpoint(args, true)
+}
+
+func cmdRestore(w *Workspace, args string) error {
+	c := w.checkpoints[args]
+	if c == nil {
+		return errors.New("no checkpoint named " + args)
+	}
+	w.restore(c.snapshot)
+	w.fork = ""
+	if c.fork {
+		w.fork = args
+	}
+	return nil
+}
@@ -0,0 +1,434 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// command is a REPL command, invoked as :name followed by its arguments
+type command struct {
+	name string
+	args string
+	help string
+	// match tells, for a line that is valid go as well, whether its
+	// arguments still make it the command when it is invoked by shortcut,
+	// unless they start with a name the workspace declares
+	match *regexp.Regexp
+	exec  func(w *Workspace, args string) error
+}
+
+var commands []*command
+
+func init() {
+	commands = []*command{
+		{name: "help", help: "help menu", exec: cmdHelp},
+		{name: "rm", args: "[dpc][#],[#]-[#],...", help: "pop last/specific (declaration|package|code)",
+			match: regexp.MustCompile(`^[dpc][\d,\-\s]*$`), exec: cmdRm},
+		{name: "replace", args: "[dpc]# code", help: "replace entry with code, kept if it compiles and runs", exec: cmdReplace},
+		{name: "move", args: "[dc]# #", help: "move entry before entry #, or to the end", exec: cmdMove},
+		{name: "swap", args: "[dc]# [dc]#", help: "swap two entries", exec: cmdSwap},
+		{name: "run", args: "[KEY=value...] [args...] [< file|<<< text]", help: "run the program, built again only if it changed, [with environment, arguments or stdin]", exec: cmdRun},
+		{name: "src", args: "[!]", help: "inspect source [with linenum]", exec: cmdSrc},
+		{name: "edit", args: "[[dpc]#]", help: "edit entry or whole session in $EDITOR, kept if it compiles", exec: cmdEdit},
+		{name: "load", args: "tmpl", help: "source tmpl", exec: cmdLoad},
+		{name: "save", args: "tmpl", help: "write tmpl", exec: cmdSave},
+		{name: "paste", help: "read lines up to paste_end (:end) or ^D and run them as one input", exec: cmdPaste},
+		{name: "history", args: "[[list] n|grep pattern|run #]", help: "list last n history entries, search them, or run one again", exec: cmdHistory},
+		{name: "reset", help: "reset", exec: cmdReset},
+		{name: "undo", help: "undo last change to the workspace", exec: cmdUndo},
+		{name: "redo", help: "redo change undone", exec: cmdRedo},
+		{name: "checkpoint", args: "[name]", help: "save workspace as name, or list checkpoints", exec: cmdCheckpoint},
+		{name: "fork", args: "name", help: "save workspace as name, and keep it up to date with the changes that follow", exec: cmdFork},
+		{name: "restore", args: "name", help: "go back to checkpoint name", exec: cmdRestore},
+		{name: "session", args: "[name]", help: "list sessions, or go on as session name [loading its journal]", exec: cmdSession},
+		{name: "env", args: "[KEY=value...|-u KEY...]", help: "list or change environment of the program", exec: cmdEnv},
+		{name: "cd", args: "[dir]", help: "show or change directory the program runs in", exec: cmdCd},
+		{name: "build", args: "[flags...|reset]", help: "show or change -tags, -race, -trimpath, -gcflags, -ldflags, -X, CGO_ENABLED, GOEXPERIMENT, GOAMD64, GOOS and GOARCH of every compile", exec: cmdBuild},
+		{name: "list", help: "tmpl list", exec: cmdList},
+		{name: "arg", args: "[args]", help: "set or get command-line argument", exec: cmdArg},
+		{name: "config", args: "[key [value]|save]", help: "show or change settings [save to config.json]", exec: cmdConfig},
+		{name: "md", args: "[file]", help: "write session as markdown [to file]", exec: cmdMd},
+		{name: "mod", args: "dir [test]", help: "write session as go module in new dir [with test]", exec: cmdMod},
+		{name: "alias", args: "[name [expansion]]", help: "show or define alias, $* and $1-$9 expand to arguments", exec: cmdAlias},
+		{name: "unalias", args: "name", help: "remove alias", exec: cmdUnalias},
+		{name: "example", args: "[-f] dir [name]", help: "write last run as Example[name] test in package dir [replacing its file]", exec: cmdExample},
+	}
+}
+
+func findCommand(name string) *command {
+	for _, cmd := range commands {
+		if cmd.name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+func parsesAsGo(line string) bool {
+	fset := token.NewFileSet()
+	if _, err := parseDeclList(fset, "gop", line); err == nil {
+		return true
+	}
+	_, err := parseStmtList(fset, "gop", line)
+	return err == nil
+}
+
+// shortcutKeys returns the configured shortcuts, longest first
+func shortcutKeys() []string {
+	keys := []string{}
+	for key, name := range conf.Shortcuts {
+		if key != "" && name != "" {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if len(keys[i]) != len(keys[j]) {
+			return len(keys[i]) > len(keys[j])
+		}
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// parseCommand finds the command line invokes. A line starting with ':' is
+// always a command. A shortcut, such as '-' for :rm or 'reset' for :reset,
+// gives way to go code: it only counts if line is exactly the shortcut, if
+// it is not valid go, or if its arguments match the command's pattern and
+// do not start with a name declared in w, as -c3 does.
+func parseCommand(w *Workspace, line string) (cmd *command, args string, ok bool) {
+	if strings.HasPrefix(line, ":") {
+		name := line[1:]
+		if p := strings.IndexFunc(name, unicode.IsSpace); p != -1 {
+			name, args = name[:p], strings.TrimSpace(name[p:])
+		}
+		return findCommand(name), args, true
+	}
+
+	for _, key := range shortcutKeys() {
+		if unicode.IsLetter([]rune(key)[0]) {
+			if line != key &&
+				!strings.HasPrefix(line, key+" ") &&
+				!strings.HasPrefix(line, key+"\t") {
+				continue
+			}
+		} else if !strings.HasPrefix(line, key) {
+			continue
+		}
+
+		cmd = findCommand(conf.Shortcuts[key])
+		if cmd == nil {
+			continue
+		}
+		args = strings.TrimSpace(line[len(key):])
+		if line == key || !parsesAsGo(line) ||
+			(cmd.match != nil && cmd.match.MatchString(args) && !w.declares(leadingName(args))) {
+			return cmd, args, true
+		}
+		return nil, "", false
+	}
+	return nil, "", false
+}
+
+// leadingName returns the identifier s starts with
+func leadingName(s string) string {
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+	if end == -1 {
+		return s
+	}
+	return s[:end]
+}
+
+// declares tells whether an entry of w declares name at the top level of
+// the program or of main
+func (w *Workspace) declares(name string) bool {
+	names := []string{}
+	for _, def := range w.defs {
+		names = append(names, declNames(def)...)
+	}
+	for _, p := range w.entries('p') {
+		spec := p.(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
+		if spec.Name != nil {
+			names = append(names, spec.Name.Name)
+		} else {
+			path, _ := strconv.Unquote(spec.Path.Value)
+			names = append(names, filepath.Base(path))
+		}
+	}
+	for _, code := range w.codes {
+		switch v := code.(type) {
+		case *ast.AssignStmt:
+			if v.Tok == token.DEFINE {
+				for _, expr := range v.Lhs {
+					if ident, ok := expr.(*ast.Ident); ok {
+						names = append(names, ident.Name)
+					}
+				}
+			}
+		case *ast.DeclStmt:
+			names = append(names, declNames(v.Decl)...)
+		}
+	}
+	for _, declared := range names {
+		if declared == name {
+			return true
+		}
+	}
+	return false
+}
+
+func cmdHelp(w *Workspace, args string) error {
+	shortcuts := map[string][]string{}
+	for _, key := range shortcutKeys() {
+		if name := conf.Shortcuts[key]; name != key {
+			shortcuts[name] = append(shortcuts[name], key)
+		}
+	}
+
+	fmt.Println("Commands:")
+	for _, cmd := range commands {
+		usage := ":" + cmd.name
+		if cmd.args != "" {
+			usage += " " + cmd.args
+		}
+		if keys := shortcuts[cmd.name]; len(keys) > 0 {
+			sort.Strings(keys)
+			usage += " (" + strings.Join(keys, "|") + ")"
+		}
+		fmt.Printf("\t%s\t%s\n", usage, cmd.help)
+	}
+	fmt.Println("\t[#](...)\tadd def or code")
+
+	fmt.Println("Aliases:")
+	for _, name := range aliasNames() {
+		fmt.Printf("\t%s\t%s\n", name, conf.Aliases[name])
+	}
+	return nil
+}
+
+func cmdRm(w *Workspace, args string) error {
+	removeByIndex(w, args)
+	return nil
+}
+
+func cmdSrc(w *Workspace, args string) error {
+	if args == "!" {
+		fmt.Println(w.source(true, true, true))
+	} else {
+		fmt.Println(w.source(true, false, true))
+	}
+	return nil
+}
+
+// lockName returns the file lockFile locks for file
+func lockName(file string) string {
+	return filepath.Join(filepath.Dir(file), "."+filepath.Base(file)+".lock")
+}
+
+// readFileLocked reads file while no other gop writes it
+func readFileLocked(file string) ([]byte, error) {
+	if _, err := os.Stat(file); err != nil {
+		return nil, err
+	}
+	unlock, err := lockFile(file, false)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+	return ioutil.ReadFile(file)
+}
+
+// writeFileLocked replaces file with data, so that another gop reads either
+// the old or the new file
+func writeFileLocked(file string, data []byte) error {
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	return replaceFile(file, data)
+}
+
+// replaceFile writes data to a new file and renames it to file
+func replaceFile(file string, data []byte) error {
+	tmp := file + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, file)
+}
+
+func cmdSave(w *Workspace, args string) error {
+	if args == "" {
+		return nil
+	}
+	file := filepath.Join(home, args)
+	if !strings.HasSuffix(file, ".tmpl") {
+		file += ".tmpl"
+	}
+	return writeFileLocked(file, []byte(w.metadata()+w.source(false, false, false)))
+}
+
+func cmdLoad(w *Workspace, args string) error {
+	file := args
+	if file == "" {
+		return errors.New("no file specified for include")
+	}
+	if !strings.HasSuffix(file, ".tmpl") {
+		file += ".tmpl"
+	}
+	bs, err := readFileLocked(file)
+	if err != nil {
+		if os.IsNotExist(err) {
+			bs, err = readFileLocked(filepath.Join(home, file))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	src, err := w.readMetadata(string(bs))
+	if err != nil {
+		return err
+	}
+	return sourceTmpl(w, src)
+}
+
+// sourceTmpl replaces the workspace with the program in src
+func sourceTmpl(w *Workspace, src string) (err error) {
+	sepBegin, sepEnd := "func main() {", "}"
+	if pos := strings.Index(src, sepBegin); pos != -1 {
+		src = src[:pos] + src[pos+len(sepBegin):]
+		if pos := strings.LastIndex(src, sepEnd); pos != -1 {
+			src = src[:pos] + src[pos+len(sepEnd):]
+		}
+	}
+
+	w.pkgs = nil
+	w.pkgsNotimport = nil
+	w.codes = nil
+	w.defs = nil
+	w.transcript = nil
+	tmpline := ""
+	for _, line := range strings.Split(src, "\n") {
+		tmpline += line + "\n"
+		var notComplete bool
+		notComplete, err = parseGo4import(w, tmpline)
+		if err != nil {
+			break
+		}
+		if notComplete {
+			continue
+		}
+		tmpline = ""
+	}
+	if err == nil && strings.TrimSpace(tmpline) != "" {
+		err = errors.New("incomplete input at end")
+	}
+	sourceDefaultDPC(w)
+	return
+}
+
+func cmdReset(w *Workspace, args string) error {
+	w.pkgs = nil
+	w.pkgsNotimport = nil
+	w.defs = nil
+	w.codes = nil
+	w.transcript = nil
+	sourceDefaultDPC(w)
+	return nil
+}
+
+func cmdList(w *Workspace, args string) error {
+	entries, err := ioutil.ReadDir(home)
+	if err != nil {
+		return err
+	}
+
+	tmpls := []string{}
+	for _, fi := range entries {
+		if fi.IsDir() {
+			continue
+		}
+
+		name := fi.Name()
+		if strings.HasPrefix(name, ".") ||
+			!strings.HasSuffix(name, ".tmpl") {
+			continue
+		}
+
+		tmpls = append(tmpls, name)
+	}
+	for pos, tmpl := range tmpls {
+		fmt.Printf("%d\t%s\n", pos, tmpl)
+	}
+	return nil
+}
+
+func cmdArg(w *Workspace, args string) error {
+	if args == "" {
+		fmt.Printf("%s\n", w.args)
+		return nil
+	}
+	if _, err := splitWords(args); err != nil {
+		return err
+	}
+	w.args = args
+	return nil
+}
+
+func cmdConfig(w *Workspace, args string) error {
+	return execConfig(args)
+}
+
+func cmdMd(w *Workspace, args string) error {
+	if args == "" {
+		fmt.Print(w.markdown())
+		return nil
+	}
+	file := args
+	if !strings.HasSuffix(file, ".md") {
+		file += ".md"
+	}
+	return ioutil.WriteFile(file, []byte(w.markdown()), 0644)
+}
+
+func cmdMod(w *Workspace, args string) error {
+	fields := strings.Fields(args)
+	if len(fields) == 0 {
+		return errors.New("no directory specified for mod")
+	}
+	withTest := len(fields) > 1 && fields[1] == "test"
+	return exportModule(w, fields[0], withTest)
+}
+
+func cmdExample(w *Workspace, args string) error {
+	fields := strings.Fields(args)
+	overwrite := len(fields) > 0 && fields[0] == "-f"
+	if overwrite {
+		fields = fields[1:]
+	}
+	if len(fields) == 0 {
+		return errors.New("no directory specified for example")
+	}
+	name := ""
+	if len(fields) > 1 {
+		name = fields[1]
+	}
+	file, err := exportExample(w, fields[0], name, overwrite)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Wrote", file)
+	return nil
+}
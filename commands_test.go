@@ -0,0 +1,108 @@
+package main
+
+import (
+	"go/token"
+	"testing"
+)
+
+func TestParseCommand(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		ok   bool
+		name string
+		args string
+	}{
+		{":rm c3", true, "rm", "c3"},
+		{":src", true, "src", ""},
+		{":run  -v  x", true, "run", "-v  x"},
+		{":nosuch", true, "", ""},
+		{"-", true, "rm", ""},
+		{"-c 3", true, "rm", "c 3"},
+		{"-c", true, "rm", "c"},
+		{"-c3", true, "rm", "c3"},
+		{"-d1", true, "rm", "d1"},
+		{"-p2", true, "rm", "p2"},
+		{"-c0-9", true, "rm", "c0-9"},
+		{"-c1,3", true, "rm", "c1,3"},
+		{"-x", false, "", ""},
+		{"-c.x", false, "", ""},
+		{"-c3 * 2", false, "", ""},
+		{"!", true, "src", ""},
+		{"!!", true, "src", "!"},
+		{"!ok", false, "", ""},
+		{"<tmpl", true, "load", "tmpl"},
+		{"<-ch", false, "", ""},
+		{">tmpl", true, "save", "tmpl"},
+		{"reset", true, "reset", ""},
+		{"reset()", false, "", ""},
+		{"list = append(list, 1)", false, "", ""},
+		{"arg := 1", false, "", ""},
+		{"arg -x", false, "", ""},
+		{"run", true, "run", ""},
+		{"run <<< 4", true, "run", "<<< 4"},
+		{"run < file", false, "", ""},
+		{"session work", true, "session", "work"},
+		{"edit c1", true, "edit", "c1"},
+		{"md", true, "md", ""},
+		{"md out.md", true, "md", "out.md"},
+		{"md /= 2", false, "", ""},
+		{"mod /= 2", false, "", ""},
+		{"mod dir test", true, "mod", "dir test"},
+		{"example /= 2", false, "", ""},
+		{"x := 1", false, "", ""},
+		{"cd", true, "cd", ""},
+		{"cd ~/src", true, "cd", "~/src"},
+		{"cd ..", true, "cd", ".."},
+		{"cd -= 1", false, "", ""},
+		{"cd /= 2", false, "", ""},
+		{"cd.x = 1", false, "", ""},
+		{"env", true, "env", ""},
+		{"env A=1", true, "env", "A=1"},
+		{"env -u A", true, "env", "-u A"},
+		{"env -= 1", false, "", ""},
+		{"env = os.Environ()", false, "", ""},
+		{"build", true, "build", ""},
+		{"build reset", true, "build", "reset"},
+		{"build -tags x -race", true, "build", "-tags x -race"},
+		{"build CGO_ENABLED=0", true, "build", "CGO_ENABLED=0"},
+		{"build -= 1", false, "", ""},
+		{"build -race", false, "", ""},
+	} {
+		cmd, args, ok := parseCommand(&Workspace{}, c.line)
+		name := ""
+		if cmd != nil {
+			name = cmd.name
+		}
+		if ok != c.ok || name != c.name || args != c.args {
+			t.Errorf("parseCommand(%q) = %q, %q, %v; want %q, %q, %v", c.line, name, args, ok, c.name, c.args, c.ok)
+		}
+	}
+}
+
+func TestParseCommandDeclared(t *testing.T) {
+	w := &Workspace{files: token.NewFileSet()}
+	for _, src := range []string{`import c "strings"`, "var d1 = 1", "c3 := 1", "var c4, c5 int"} {
+		if _, err := parseGo4import(w, src); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []struct {
+		line string
+		rm   bool
+	}{
+		{"-c", false},
+		{"-c3", false},
+		{"-c5", false},
+		{"-d1", false},
+		{"-c0-9", true},
+		{"-c2", true},
+		{"-d0", true},
+		{"-c3,4", true},
+		{"-", true},
+	} {
+		cmd, _, ok := parseCommand(w, c.line)
+		if rm := ok && cmd != nil && cmd.name == "rm"; rm != c.rm {
+			t.Errorf("parseCommand(%q) is rm: %v; want %v", c.line, rm, c.rm)
+		}
+	}
+}
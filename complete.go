@@ -92,6 +92,15 @@ func completeTmpl(prefix string) (result []string) {
 	return
 }
 
+func completeCommand(prefix string) (result []string) {
+	for _, cmd := range commands {
+		if name := ":" + cmd.name; strings.HasPrefix(name, prefix) {
+			result = append(result, name+" ")
+		}
+	}
+	return
+}
+
 func completeCode(source, in string, pos int) (keep int, candidates []string, err error) {
 	p := strings.LastIndex(source, "}")
 	if p == -1 {
@@ -126,12 +135,16 @@ func (w *Workspace) completeWord(line string, pos int) (string, []string, string
 		}
 	}
 
-	for _, cmdPrefix := range []string{"<", ">"} {
+	if strings.HasPrefix(line, ":") && !strings.ContainsAny(line[:pos], " \t") {
+		return "", completeCommand(line[:pos]), line[pos:]
+	}
+
+	for _, cmdPrefix := range []string{":load ", ":save ", "<", ">"} {
 		if strings.HasPrefix(line, cmdPrefix) {
 			i := strings.Index(line, cmdPrefix)
 			if i != -1 {
-				if pos > i {
-					return line[:i+1], completeTmpl(line[i+1 : pos]), line[pos:]
+				if n := i + len(cmdPrefix); pos >= n {
+					return line[:n], completeTmpl(line[n:pos]), line[pos:]
 				}
 			}
 		}
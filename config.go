@@ -0,0 +1,297 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+)
+
+// duration is a time.Duration written as "10s" in config files
+type duration struct {
+	time.Duration
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+func (d *duration) UnmarshalJSON(bs []byte) (err error) {
+	var s string
+	if err = json.Unmarshal(bs, &s); err != nil {
+		return
+	}
+	d.Duration, err = time.ParseDuration(s)
+	return
+}
+
+// Config holds the settings read from $HOME/.gop/config.json, overridden by
+// gop.json in the working directory
+type Config struct {
+	Imports     []string `json:"imports"`
+	Prompt      string   `json:"prompt"`
+	Home        string   `json:"home"`
+	Go          string   `json:"go"`
+	BuildFlags  []string `json:"build_flags"`
+	HistorySize int      `json:"history_size"`
+	RunTimeout  duration `json:"run_timeout"`
+	// RunPty runs the program in a pseudo-terminal, on linux, so that it
+	// finds a terminal on its stdout and stderr
+	RunPty   bool   `json:"run_pty"`
+	PasteEnd string `json:"paste_end"`
+	// HistoryDedup keeps only the latest copy of repeated history entries
+	HistoryDedup bool `json:"history_dedup"`
+	// HistoryPerProject keeps a history file for each module, or for each
+	// directory outside of modules
+	HistoryPerProject bool `json:"history_per_project"`
+	// Shortcuts maps legacy command forms to command names; an empty
+	// name disables a shortcut
+	Shortcuts map[string]string `json:"shortcuts"`
+	// Aliases rewrite a line starting with the alias name: $* in the
+	// expansion is replaced by the rest of the line, and $1 to $9 by
+	// its comma separated parameters
+	Aliases map[string]string `json:"aliases"`
+	// EditMode is emacs or vi
+	EditMode string `json:"edit_mode"`
+	// KeyBindings map keys such as C-o or M-s to an editor action, to a
+	// command line starting with ':' that is run at once, or to insert:text.
+	// ViKeyBindings do the same in vi's normal mode.
+	KeyBindings   map[string]string `json:"key_bindings"`
+	ViKeyBindings map[string]string `json:"vi_key_bindings"`
+}
+
+var conf = Config{
+	Imports: []string{
+		"fmt",
+		"strconv",
+		"strings",
+		"time",
+		"encoding/json",
+		"bytes",
+	},
+	Prompt:       "GOP$ ",
+	Home:         "~/.gop",
+	Go:           "go",
+	HistorySize:  1000,
+	HistoryDedup: true,
+	PasteEnd:     ":end",
+	Shortcuts: map[string]string{
+		"?":          "help",
+		"help":       "help",
+		"-":          "rm",
+		"!":          "src",
+		"run":        "run",
+		"edit":       "edit",
+		"replace":    "replace",
+		"move":       "move",
+		"swap":       "swap",
+		"<":          "load",
+		">":          "save",
+		"reset":      "reset",
+		"undo":       "undo",
+		"redo":       "redo",
+		"checkpoint": "checkpoint",
+		"fork":       "fork",
+		"restore":    "restore",
+		"session":    "session",
+		"env":        "env",
+		"cd":         "cd",
+		"build":      "build",
+		"list":       "list",
+		"arg":        "arg",
+		"config":     "config",
+		"md":         "md",
+		"mod":        "mod",
+		"example":    "example",
+	},
+	Aliases: map[string]string{
+		"echo": "println($*)",
+	},
+	EditMode: "emacs",
+	KeyBindings: map[string]string{
+		"M-s": ":src",
+		"M-r": "insert-last-result",
+	},
+	ViKeyBindings: map[string]string{},
+}
+
+// defaultConfig holds the settings of gop before any config file, and
+// configChanges those made with the config command
+var (
+	defaultConfig, _ = json.Marshal(conf)
+	configChanges    = map[string]json.RawMessage{}
+)
+
+// configFiles returns the config files in the order they are applied
+func configFiles() []string {
+	return []string{filepath.Join(home, "config.json"), "gop.json"}
+}
+
+func loadConfig() {
+	for _, file := range configFiles() {
+		bs, err := ioutil.ReadFile(file)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				fmt.Println("ReadFile error:", err)
+			}
+			continue
+		}
+		if err := json.Unmarshal(bs, &conf); err != nil {
+			fmt.Printf("Config %s error: %v\n", file, err)
+		}
+	}
+	if err := conf.check(); err != nil {
+		fmt.Println("Config error:", err)
+	}
+	applyConfig()
+}
+
+// check reports settings that cannot take effect
+func (c *Config) check() error {
+	if c.RunPty && runtime.GOOS != "linux" {
+		return errors.New("run_pty is only supported on linux")
+	}
+	if c.EditMode != "emacs" && c.EditMode != "vi" {
+		return errors.New("edit_mode is neither emacs nor vi: " + c.EditMode)
+	}
+	for _, bindings := range []map[string]string{c.KeyBindings, c.ViKeyBindings} {
+		for key, action := range bindings {
+			if !isEditAction(action) {
+				return fmt.Errorf("key %s bound to unknown action %s", key, action)
+			}
+		}
+	}
+	return nil
+}
+
+// applyConfig makes settings that live outside conf take effect
+func applyConfig() {
+	if dir := expandHome(conf.Home); dir != "" {
+		home = dir
+	}
+}
+
+// globalConfig returns the settings of the global config file with the
+// changes made by the config command, leaving out those of gop.json
+func globalConfig() (Config, error) {
+	file := filepath.Join(home, "config.json")
+	c := Config{}
+	if err := json.Unmarshal(defaultConfig, &c); err != nil {
+		return c, err
+	}
+	bs, err := ioutil.ReadFile(file)
+	if err == nil {
+		err = json.Unmarshal(bs, &c)
+	} else if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil {
+		return c, fmt.Errorf("config %s: %v", file, err)
+	}
+	return changeConfig(c, configChanges)
+}
+
+// saveConfig writes the settings of the global config file, with the
+// changes made by the config command, to it. Those of gop.json stay in
+// their project.
+func saveConfig() error {
+	c, err := globalConfig()
+	if err != nil {
+		return err
+	}
+	bs, err := json.MarshalIndent(c, "", "\t")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(home, "config.json"), append(bs, '\n'), 0644)
+}
+
+// configSettings returns the settings of c by key
+func configSettings(c Config) (map[string]json.RawMessage, error) {
+	bs, err := json.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	settings := map[string]json.RawMessage{}
+	err = json.Unmarshal(bs, &settings)
+	return settings, err
+}
+
+// changeConfig returns c with the settings in changes
+func changeConfig(c Config, changes map[string]json.RawMessage) (Config, error) {
+	settings, err := configSettings(c)
+	if err != nil {
+		return c, err
+	}
+	for key, value := range changes {
+		settings[key] = value
+	}
+	bs, err := json.Marshal(settings)
+	if err != nil {
+		return c, err
+	}
+	changed := Config{}
+	dec := json.NewDecoder(bytes.NewReader(bs))
+	dec.DisallowUnknownFields()
+	if err = dec.Decode(&changed); err != nil {
+		return c, err
+	}
+	return changed, changed.check()
+}
+
+// execConfig shows settings, or sets key to value. The value is read as
+// json, and as a plain string if it is not valid json.
+func execConfig(cmdArgs string) error {
+	if cmdArgs == "save" {
+		return saveConfig()
+	}
+
+	settings, err := configSettings(conf)
+	if err != nil {
+		return err
+	}
+
+	key, value := cmdArgs, ""
+	if p := strings.IndexAny(cmdArgs, " \t"); p != -1 {
+		key, value = cmdArgs[:p], strings.TrimSpace(cmdArgs[p+1:])
+	}
+
+	if key == "" {
+		keys := []string{}
+		for k := range settings {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			fmt.Printf("%s\t%s\n", k, settings[k])
+		}
+		return nil
+	}
+	if _, ok := settings[key]; !ok {
+		return errors.New("unknown setting: " + key)
+	}
+	if value == "" {
+		fmt.Printf("%s\t%s\n", key, settings[key])
+		return nil
+	}
+
+	raw := []byte(value)
+	if !json.Valid(raw) {
+		raw, _ = json.Marshal(value)
+	}
+	c, err := changeConfig(conf, map[string]json.RawMessage{key: raw})
+	if err != nil {
+		return err
+	}
+	conf = c
+	configChanges[key] = raw
+	applyConfig()
+	return os.MkdirAll(home, 0755)
+}
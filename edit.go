@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// clone returns a copy of w whose entries can be changed without touching w
+func (w *Workspace) clone() *Workspace {
+	c := *w
+	c.pkgs = append([]interface{}(nil), w.pkgs...)
+	c.pkgsNotimport = append([]interface{}(nil), w.pkgsNotimport...)
+	c.defs = append([]interface{}(nil), w.defs...)
+	c.codes = append([]interface{}(nil), w.codes...)
+	return &c
+}
+
+// entries returns the entries of kind d, p or c, packages in the order
+// :src numbers them
+func (w *Workspace) entries(kind byte) []interface{} {
+	switch kind {
+	case 'd':
+		return w.defs
+	case 'p':
+		return append(append([]interface{}(nil), w.pkgs...), w.pkgsNotimport...)
+	case 'c':
+		return w.codes
+	}
+	return nil
+}
+
+// entryIndex parses an entry such as c3, or c for the last code
+func (w *Workspace) entryIndex(arg string) (kind byte, pos int, err error) {
+	if arg == "" {
+		err = errors.New("no item specified")
+		return
+	}
+	kind = arg[0]
+	if !strings.ContainsRune("dpc", rune(kind)) {
+		err = fmt.Errorf("invalid item type '%c'", kind)
+		return
+	}
+	n := len(w.entries(kind))
+	if n == 0 {
+		err = fmt.Errorf("no '%c' entries", kind)
+		return
+	}
+	pos = n - 1
+	if num := strings.TrimSpace(arg[1:]); num != "" {
+		if pos, err = strconv.Atoi(num); err != nil {
+			err = fmt.Errorf("%s not integer", num)
+			return
+		}
+		if pos < 0 || pos >= n {
+			err = fmt.Errorf("%d out of range", pos)
+		}
+	}
+	return
+}
+
+func splice(list []interface{}, pos int, items []interface{}) []interface{} {
+	return append(append(append([]interface{}(nil), list[:pos]...), items...), list[pos+1:]...)
+}
+
+// replaceEntry puts the statements or declarations in src in place of entry
+// pos of kind, or removes the entry if src is empty. Code takes statements,
+// d entries declarations and p entries imports.
+func (w *Workspace) replaceEntry(kind byte, pos int, src string) error {
+	items := []interface{}{}
+	if strings.TrimSpace(src) != "" {
+		if kind == 'c' {
+			stmts, err := parseStmtList(w.files, "gop", src)
+			if err != nil {
+				return syntaxError(src, err)
+			}
+			items, _ = useDefined(w.files, stmts)
+		} else {
+			decls, err := parseDeclList(w.files, "gop", src)
+			if err != nil {
+				return syntaxError(src, err)
+			}
+			for _, decl := range decls {
+				v, ok := decl.(*ast.GenDecl)
+				if isImport := ok && v.Tok == token.IMPORT; isImport != (kind == 'p') {
+					return fmt.Errorf("%c%d does not take %s", kind, pos, strings.SplitN(w.printNode(decl), "\n", 2)[0])
+				}
+				if kind == 'd' {
+					items = append(items, decl)
+					continue
+				}
+				for _, spec := range v.Specs {
+					tree, _ := parseDeclList(w.files, "gop", "import "+w.printNode(spec))
+					items = append(items, tree[0])
+				}
+			}
+		}
+	}
+
+	switch {
+	case kind == 'c':
+		w.codes = splice(w.codes, pos, items)
+	case kind == 'd':
+		w.defs = splice(w.defs, pos, items)
+	case pos < len(w.pkgs):
+		w.pkgs = splice(w.pkgs, pos, items)
+	default:
+		w.pkgsNotimport = splice(w.pkgsNotimport, pos-len(w.pkgs), items)
+	}
+	return nil
+}
+
+// syntaxError returns the error parseInput finds in src, which gives
+// positions within src, or err if parseInput finds none
+func syntaxError(src string, err error) error {
+	_, notComplete, inputErr := parseInput(token.NewFileSet(), src)
+	if inputErr != nil {
+		return inputErr
+	}
+	if notComplete {
+		return errors.New("incomplete input")
+	}
+	return err
+}
+
+func (w *Workspace) printNode(node interface{}) string {
+	buf := new(bytes.Buffer)
+	printer.Fprint(buf, w.files, node)
+	return buf.String()
+}
+
+// swapSource loads src into a new workspace the way a template is loaded,
+// and puts it in place of w's entries if it compiles
+func (w *Workspace) swapSource(src string) error {
+	c := &Workspace{files: token.NewFileSet(), args: w.args, env: w.env, dir: w.dir, build: w.build}
+	if err := sourceTmpl(c, src); err != nil {
+		return err
+	}
+	if err := compileImports(c); err != nil {
+		return err
+	}
+	w.pkgs, w.pkgsNotimport = c.pkgs, c.pkgsNotimport
+	w.defs, w.codes = c.defs, c.codes
+	w.files = c.files
+	return nil
+}
+
+// editorCommand returns the command line of the user's editor
+func editorCommand() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
+
+// editText opens text in the user's editor as a file named name, and
+// returns the text that was saved
+func editText(name, text string) (string, error) {
+	dir, err := ioutil.TempDir("", "gop")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, name)
+	if err = ioutil.WriteFile(file, []byte(text), 0644); err != nil {
+		return "", err
+	}
+
+	var paste *pasteReader
+	if rl != nil {
+		paste = rl.paste
+	}
+	args := editorCommand()
+	cmd := exec.Command(args[0], append(args[1:], file)...)
+	cmd.Stdin = paste.terminal()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	paste.pause()
+	err = cmd.Run()
+	paste.resume()
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", args[0], err)
+	}
+
+	bs, err := ioutil.ReadFile(file)
+	return string(bs), err
+}
+
+// cmdEdit opens an entry, or the whole session, in the user's editor. The
+// session with the text saved is loaded like a template, and replaces the
+// workspace if it compiles.
+func cmdEdit(w *Workspace, args string) error {
+	if args == "" {
+		src := w.source(false, false, true)
+		text, err := editText("gop.go", src)
+		if err != nil || text == src {
+			return err
+		}
+		return w.swapSource(text)
+	}
+
+	kind, pos, err := w.entryIndex(args)
+	if err != nil {
+		return err
+	}
+	src := w.printNode(w.entries(kind)[pos]) + "\n"
+	text, err := editText(fmt.Sprintf("%c%d.go", kind, pos), src)
+	if err != nil || text == src {
+		return err
+	}
+	c := w.clone()
+	if err = c.replaceEntry(kind, pos, text); err != nil {
+		return err
+	}
+	return w.swapSource(c.source(false, false, true))
+}
+
+// list returns the entries of kind d or c, which have an order
+func (w *Workspace) list(kind byte) *[]interface{} {
+	switch kind {
+	case 'd':
+		return &w.defs
+	case 'c':
+		return &w.codes
+	}
+	return nil
+}
+
+// moveEntry moves entry from of kind before the entry at to, or to the end
+// if to is the number of entries
+func (w *Workspace) moveEntry(kind byte, from, to int) error {
+	list := w.list(kind)
+	if list == nil {
+		return fmt.Errorf("'%c' entries have no order", kind)
+	}
+	if to < 0 || to > len(*list) {
+		return fmt.Errorf("%d out of range", to)
+	}
+	item := (*list)[from]
+	rest := splice(*list, from, nil)
+	if to > from {
+		to--
+	}
+	*list = append(rest[:to], append([]interface{}{item}, rest[to:]...)...)
+	return nil
+}
+
+func (w *Workspace) swapEntries(kind byte, i, j int) error {
+	list := w.list(kind)
+	if list == nil {
+		return fmt.Errorf("'%c' entries have no order", kind)
+	}
+	*list = append([]interface{}(nil), *list...)
+	(*list)[i], (*list)[j] = (*list)[j], (*list)[i]
+	return nil
+}
+
+// apply makes change to entries of kind, then compiles the program as
+// parseGo does, and puts w back the way it was if that fails. A change of
+// code also runs the program. The change is added to the transcript as
+// input.
+func (w *Workspace) apply(kind byte, input string, change func() error) error {
+	bkup := w.clone()
+	err := change()
+	if err == nil {
+		err = compileImports(w)
+	}
+	s := &step{
+		input: input,
+		codes: append([]interface{}(nil), w.codes...),
+		added: newEntries(bkup.entries(kind), w.entries(kind)),
+	}
+	if err == nil && kind == 'c' && !w.build.cross() {
+		s.stdout, s.stderr, err = run(w)
+		s.ran = true
+	}
+	if err == nil {
+		w.pruneTranscript(newEntries(w.entries(kind), bkup.entries(kind)))
+		w.addStep(s, bkup.codes)
+	}
+	if err != nil {
+		w.pkgs, w.pkgsNotimport = bkup.pkgs, bkup.pkgsNotimport
+		w.defs, w.codes = bkup.defs, bkup.codes
+		w.files = bkup.files
+	}
+	return err
+}
+
+// cutField splits the first field off s
+func cutField(s string) (field, rest string) {
+	s = strings.TrimSpace(s)
+	if p := strings.IndexAny(s, " \t"); p != -1 {
+		return s[:p], strings.TrimSpace(s[p+1:])
+	}
+	return s, ""
+}
+
+func cmdReplace(w *Workspace, args string) error {
+	entry, code := cutField(args)
+	if code == "" {
+		return errors.New("no code to replace with")
+	}
+	kind, pos, err := w.entryIndex(entry)
+	if err != nil {
+		return err
+	}
+	code = execAlias(w, code)
+	input := "// :replace " + entry + "\n" + formatInput(code)
+	return w.apply(kind, input, func() error {
+		return w.replaceEntry(kind, pos, code)
+	})
+}
+
+func cmdMove(w *Workspace, args string) error {
+	entry, target := cutField(args)
+	kind, from, err := w.entryIndex(entry)
+	if err != nil {
+		return err
+	}
+	num := target
+	if num != "" && strings.ContainsRune("dpc", rune(num[0])) {
+		if num[0] != kind {
+			return fmt.Errorf("can not move '%c' before '%c'", kind, num[0])
+		}
+		num = num[1:]
+	}
+	to, err := strconv.Atoi(num)
+	if err != nil {
+		return fmt.Errorf("%s not integer", target)
+	}
+	return w.apply(kind, "// :move "+args, func() error {
+		return w.moveEntry(kind, from, to)
+	})
+}
+
+func cmdSwap(w *Workspace, args string) error {
+	first, second := cutField(args)
+	kind, i, err := w.entryIndex(first)
+	if err != nil {
+		return err
+	}
+	kindJ, j, err := w.entryIndex(second)
+	if err != nil {
+		return err
+	}
+	if kind != kindJ {
+		return fmt.Errorf("can not swap '%c' and '%c'", kind, kindJ)
+	}
+	return w.apply(kind, "// :swap "+args, func() error {
+		return w.swapEntries(kind, i, j)
+	})
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"go/ast"
+	"go/token"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMoveEntry(t *testing.T) {
+	for _, c := range []struct {
+		kind     byte
+		from, to int
+		want     []interface{}
+		err      bool
+	}{
+		{'c', 0, 0, []interface{}{"a", "b", "c"}, false},
+		{'c', 0, 1, []interface{}{"a", "b", "c"}, false},
+		{'c', 0, 2, []interface{}{"b", "a", "c"}, false},
+		{'c', 0, 3, []interface{}{"b", "c", "a"}, false},
+		{'c', 2, 0, []interface{}{"c", "a", "b"}, false},
+		{'c', 1, 3, []interface{}{"a", "c", "b"}, false},
+		{'c', 2, 3, []interface{}{"a", "b", "c"}, false},
+		{'c', 0, 4, []interface{}{"a", "b", "c"}, true},
+		{'c', 0, -1, []interface{}{"a", "b", "c"}, true},
+		{'d', 2, 0, []interface{}{"c", "a", "b"}, false},
+		{'p', 0, 1, []interface{}{"a", "b", "c"}, true},
+	} {
+		w := &Workspace{defs: []interface{}{"a", "b", "c"}, codes: []interface{}{"a", "b", "c"}}
+		err := w.moveEntry(c.kind, c.from, c.to)
+		list := w.codes
+		if c.kind == 'd' {
+			list = w.defs
+		}
+		if (err != nil) != c.err || !reflect.DeepEqual(list, c.want) {
+			t.Errorf("move %c%d to %d: %v, %v; want %v, error %v", c.kind, c.from, c.to, list, err, c.want, c.err)
+		}
+	}
+}
+
+func TestUseDefined(t *testing.T) {
+	for _, c := range []struct {
+		src     string
+		want    string
+		defines bool
+	}{
+		{"f()", "f()", false},
+		{"x = 1", "x = 1", true},
+		{"x := 1", "x := 1; _ = x", true},
+		{"x, _, y := f()", "x, _, y := f(); _ = x; _ = y", true},
+		{"var a, b int", "var a, b int; _ = a; _ = b", true},
+		{"x := 1; f(x)", "x := 1; _ = x; f(x)", true},
+	} {
+		w := &Workspace{files: token.NewFileSet()}
+		stmts, err := parseStmtList(w.files, "gop", c.src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		items, defines := useDefined(w.files, stmts)
+		printed := []string{}
+		for _, item := range items {
+			printed = append(printed, w.printNode(item))
+		}
+		if got := strings.Join(printed, "; "); got != c.want || defines != c.defines {
+			t.Errorf("useDefined(%q) = %q, %v; want %q, %v", c.src, got, defines, c.want, c.defines)
+		}
+	}
+}
+
+func TestReplaceEntryDefines(t *testing.T) {
+	w := &Workspace{files: token.NewFileSet()}
+	stmts, _ := parseStmtList(w.files, "gop", "f(); g()")
+	w.codes = []interface{}{stmts[0], stmts[1]}
+	if err := w.replaceEntry('c', 0, "x := 1"); err != nil {
+		t.Fatal(err)
+	}
+	printed := []string{}
+	for _, code := range w.codes {
+		printed = append(printed, w.printNode(code))
+	}
+	if got, want := strings.Join(printed, "; "), "x := 1; _ = x; g()"; got != want {
+		t.Errorf("codes after replace = %q; want %q", got, want)
+	}
+}
+
+func TestNewEntries(t *testing.T) {
+	a, b, c := &ast.Ident{Name: "a"}, &ast.Ident{Name: "b"}, &ast.Ident{Name: "c"}
+	for _, cc := range []struct {
+		before, after, want []interface{}
+	}{
+		{nil, nil, []interface{}{}},
+		{[]interface{}{a, b}, []interface{}{b, a}, []interface{}{}},
+		{[]interface{}{a, b}, []interface{}{a, c}, []interface{}{c}},
+		{[]interface{}{a}, []interface{}{c, a, b}, []interface{}{c, b}},
+	} {
+		if added := newEntries(cc.before, cc.after); !reflect.DeepEqual(added, cc.want) {
+			t.Errorf("newEntries(%v, %v) = %v; want %v", cc.before, cc.after, added, cc.want)
+		}
+	}
+}
@@ -0,0 +1,885 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-runewidth"
+	"golang.org/x/term"
+)
+
+var errPromptAborted = errors.New("prompt aborted")
+
+const (
+	// keyTimeout is how long an escape waits for the rest of a key sequence
+	keyTimeout = 50 * time.Millisecond
+	tabWidth   = 4
+)
+
+// csiKeys names the escape sequences of special keys, without the leading
+// ESC [ or ESC O
+var csiKeys = map[string]string{
+	"A":    "up",
+	"B":    "down",
+	"C":    "right",
+	"D":    "left",
+	"H":    "home",
+	"F":    "end",
+	"1~":   "home",
+	"7~":   "home",
+	"4~":   "end",
+	"8~":   "end",
+	"2~":   "insert",
+	"3~":   "delete",
+	"5~":   "pgup",
+	"6~":   "pgdown",
+	"1;5C": "C-right",
+	"1;5D": "C-left",
+	"1;3C": "M-right",
+	"1;3D": "M-left",
+}
+
+// emacsKeys are the default bindings of emacs mode, and of vi's insert mode
+var emacsKeys = map[string]string{
+	"C-a":         "beginning-of-line",
+	"home":        "beginning-of-line",
+	"C-e":         "end-of-line",
+	"end":         "end-of-line",
+	"C-b":         "backward-char",
+	"left":        "backward-char",
+	"C-f":         "forward-char",
+	"right":       "forward-char",
+	"M-b":         "backward-word",
+	"C-left":      "backward-word",
+	"M-left":      "backward-word",
+	"M-f":         "forward-word",
+	"C-right":     "forward-word",
+	"M-right":     "forward-word",
+	"C-p":         "previous-line",
+	"up":          "previous-line",
+	"C-n":         "next-line",
+	"down":        "next-line",
+	"M-<":         "beginning-of-input",
+	"M->":         "end-of-input",
+	"C-d":         "delete-char-or-eof",
+	"delete":      "delete-char",
+	"C-h":         "backward-delete-char",
+	"backspace":   "backward-delete-char",
+	"C-k":         "kill-line",
+	"C-u":         "unix-line-discard",
+	"C-w":         "backward-kill-word",
+	"M-backspace": "backward-kill-word",
+	"M-d":         "kill-word",
+	"C-y":         "yank",
+	"C-_":         "undo",
+	"tab":         "complete",
+	"enter":       "accept-line",
+	"C-j":         "newline",
+	"M-enter":     "newline",
+	"C-c":         "abort",
+	"C-g":         "abort",
+	"C-l":         "clear-screen",
+	"C-r":         "search-history",
+}
+
+// editActions lists the actions keys can be bound to, besides a command
+// line starting with ':' and insert:text
+var editActions = []string{
+	"beginning-of-line", "end-of-line", "backward-char", "forward-char",
+	"backward-word", "forward-word", "previous-line", "next-line",
+	"previous-history", "next-history", "beginning-of-input", "end-of-input",
+	"delete-char", "delete-char-or-eof", "backward-delete-char",
+	"kill-line", "unix-line-discard", "backward-kill-word", "kill-word",
+	"yank", "undo", "complete", "accept-line", "newline", "abort",
+	"clear-screen", "search-history", "insert-last-result", "vi-normal-mode",
+}
+
+func isEditAction(action string) bool {
+	if strings.HasPrefix(action, ":") || strings.HasPrefix(action, "insert:") {
+		return true
+	}
+	for _, a := range editActions {
+		if a == action {
+			return true
+		}
+	}
+	return false
+}
+
+type editState struct {
+	lines    [][]rune
+	row, col int
+}
+
+// editor reads the input of the REPL. It edits the whole input, however
+// many lines it takes, with emacs or vi keys, and reads plain lines when
+// stdin is not a terminal.
+type editor struct {
+	fd     int // the terminal, -1 if stdin is not one
+	cooked *term.State
+	runes  chan rune
+	err    error
+	plain  *bufio.Reader
+	out    *bufio.Writer
+
+	history   []string
+	completer func(line string, pos int) (head string, completions []string, tail string)
+	// incomplete tells whether text needs more lines, in which case enter
+	// starts a new line instead of accepting the input
+	incomplete func(text string) bool
+	// pasted returns the text pasted since it was last called
+	pasted func() string
+	// run runs a command line bound to a key
+	run        func(line string)
+	lastResult func() string
+
+	editState
+	prompt  string
+	normal  bool // in vi's normal mode
+	typing  bool // the last key inserted a character
+	killed  []rune
+	killRow bool // killed is a whole line
+	undo    []editState
+	histPos int
+	histNew string // the input being written while history is browsed
+	tabs    int    // completions in a row
+
+	// cursor is the number of rows the cursor is below the first row of
+	// the input on screen, and rows the number of rows the input takes
+	cursor, rows int
+}
+
+func newEditor(tty *os.File) *editor {
+	e := &editor{fd: -1, out: bufio.NewWriter(os.Stdout)}
+	if fd := int(tty.Fd()); term.IsTerminal(fd) {
+		e.fd = fd
+		e.runes = make(chan rune, 1024)
+		go e.readRunes(os.Stdin)
+	} else {
+		e.plain = bufio.NewReader(os.Stdin)
+	}
+	return e
+}
+
+func (e *editor) readRunes(r io.Reader) {
+	in := bufio.NewReader(r)
+	for {
+		c, _, err := in.ReadRune()
+		if err != nil {
+			e.err = err
+			close(e.runes)
+			return
+		}
+		e.runes <- c
+	}
+}
+
+// nextRune waits for a rune, no longer than timeout if it is not zero
+func (e *editor) nextRune(timeout time.Duration) (r rune, ok bool, err error) {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		expired = time.After(timeout)
+	}
+	select {
+	case r, ok = <-e.runes:
+		if !ok {
+			err = e.err
+		}
+	case <-expired:
+	}
+	return
+}
+
+// readKey reads a key and returns its name: the character itself for
+// printable keys, C-x and M-x for control and meta keys, and names such as
+// up, enter or backspace for the others. Unknown keys are returned as "".
+func (e *editor) readKey() (string, error) {
+	r, _, err := e.nextRune(0)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case r == '\x1b':
+		return e.readEscape()
+	case r == '\r':
+		return "enter", nil
+	case r == '\t':
+		return "tab", nil
+	case r == 0x7f:
+		return "backspace", nil
+	case r < ' ':
+		return "C-" + strings.ToLower(string(r+'@')), nil
+	}
+	return string(r), nil
+}
+
+func (e *editor) readEscape() (string, error) {
+	r, ok, err := e.nextRune(keyTimeout)
+	if !ok {
+		return "esc", err
+	}
+	switch r {
+	case '[', 'O':
+		seq := ""
+		for {
+			c, ok, err := e.nextRune(keyTimeout)
+			if !ok {
+				return "", err
+			}
+			seq += string(c)
+			if c >= 0x40 && c <= 0x7e {
+				break
+			}
+		}
+		return csiKeys[seq], nil
+	case '\r':
+		return "M-enter", nil
+	case 0x7f:
+		return "M-backspace", nil
+	}
+	return "M-" + string(r), nil
+}
+
+func (e *editor) text() string {
+	lines := make([]string, len(e.lines))
+	for i, l := range e.lines {
+		lines[i] = string(l)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// setText replaces the input, and puts the cursor at its end
+func (e *editor) setText(text string) {
+	e.lines = nil
+	for _, l := range strings.Split(text, "\n") {
+		e.lines = append(e.lines, []rune(l))
+	}
+	e.row = len(e.lines) - 1
+	e.col = len(e.lines[e.row])
+}
+
+func (e *editor) bell() {
+	e.out.WriteString("\a")
+}
+
+// edit reads an input, starting from text. With again, text is the input
+// just accepted above the cursor, which is edited in place.
+func (e *editor) edit(prompt, text string, again bool) (string, error) {
+	e.prompt = prompt
+	e.setText(text)
+	if e.fd < 0 {
+		return e.readPlain()
+	}
+
+	cooked, err := term.MakeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	e.cooked = cooked
+	defer term.Restore(e.fd, cooked)
+
+	e.cursor = 0
+	if again {
+		e.cursor = e.rows
+	}
+	e.normal, e.typing, e.undo, e.tabs = false, false, nil, 0
+	e.histPos, e.histNew = len(e.history), ""
+	e.render()
+	for {
+		key, err := e.readKey()
+		if err != nil {
+			e.leave()
+			return "", err
+		}
+		if key == "" {
+			continue
+		}
+		done, err := e.press(key)
+		if done || err != nil {
+			return e.text(), err
+		}
+		e.render()
+	}
+}
+
+// readPlain reads a line from stdin that is not a terminal, after the lines
+// of the input read so far
+func (e *editor) readPlain() (string, error) {
+	prompts := e.linePrompts()
+	fmt.Print(prompts[len(prompts)-1])
+	line, err := e.plain.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		if err == io.EOF {
+			fmt.Println()
+		}
+		return "", err
+	}
+	e.lines[e.row] = []rune(strings.TrimRight(line, "\r\n"))
+	return e.text(), nil
+}
+
+// press runs the action key is bound to
+func (e *editor) press(key string) (done bool, err error) {
+	if e.normal {
+		if action, ok := conf.ViKeyBindings[key]; ok {
+			return e.do(action)
+		}
+		// escape does nothing here, so a key typed quickly after it, read
+		// as a meta key, stands for itself
+		if strings.HasPrefix(key, "M-") && len(key) > 2 {
+			return e.press(key[2:])
+		}
+		return e.viCommand(key)
+	}
+
+	action, ok := conf.KeyBindings[key]
+	if !ok && conf.EditMode == "vi" {
+		// a key typed quickly after escape reads as a meta key
+		if key == "esc" || strings.HasPrefix(key, "M-") && len(key) > 2 {
+			e.do("vi-normal-mode")
+			if key == "esc" {
+				return
+			}
+			return e.press(key[2:])
+		}
+	}
+	if !ok {
+		action, ok = emacsKeys[key]
+	}
+	if !ok {
+		if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+			if !e.typing {
+				e.save()
+			}
+			e.insert(r)
+			e.typing, e.tabs = true, 0
+		}
+		return
+	}
+	return e.do(action)
+}
+
+// do runs an action: one of editActions, a command line starting with ':',
+// or insert:text
+func (e *editor) do(action string) (done bool, err error) {
+	e.typing = false
+	if action != "complete" {
+		e.tabs = 0
+	}
+
+	if strings.HasPrefix(action, ":") {
+		e.runLine(action)
+		return
+	}
+	if strings.HasPrefix(action, "insert:") {
+		e.save()
+		e.insert([]rune(strings.TrimPrefix(action, "insert:")))
+		return
+	}
+
+	switch action {
+	case "beginning-of-line":
+		e.col = 0
+	case "end-of-line":
+		e.col = len(e.lines[e.row])
+	case "backward-char":
+		e.left()
+	case "forward-char":
+		e.right()
+	case "backward-word":
+		if e.col == 0 {
+			e.left()
+		} else {
+			e.col = wordStart(e.lines[e.row], e.col)
+		}
+	case "forward-word":
+		if e.col == len(e.lines[e.row]) {
+			e.right()
+		} else {
+			e.col = wordEnd(e.lines[e.row], e.col)
+		}
+	case "previous-line":
+		if e.row == 0 {
+			e.historyMove(-1)
+		} else {
+			e.row--
+			e.fixCol()
+		}
+	case "next-line":
+		if e.row == len(e.lines)-1 {
+			e.historyMove(1)
+		} else {
+			e.row++
+			e.fixCol()
+		}
+	case "previous-history":
+		e.historyMove(-1)
+	case "next-history":
+		e.historyMove(1)
+	case "beginning-of-input":
+		e.row, e.col = 0, 0
+	case "end-of-input":
+		e.row = len(e.lines) - 1
+		e.col = len(e.lines[e.row])
+	case "delete-char-or-eof":
+		if e.text() == "" {
+			e.leave()
+			return true, io.EOF
+		}
+		fallthrough
+	case "delete-char":
+		e.save()
+		e.deleteChar()
+	case "backward-delete-char":
+		e.save()
+		if e.left() {
+			e.deleteChar()
+		}
+	case "kill-line":
+		e.save()
+		if e.col < len(e.lines[e.row]) {
+			e.kill(e.col, len(e.lines[e.row]))
+		} else {
+			e.deleteChar()
+		}
+	case "unix-line-discard":
+		e.save()
+		e.kill(0, e.col)
+	case "backward-kill-word":
+		e.save()
+		if e.col == 0 {
+			if e.left() {
+				e.deleteChar()
+			}
+		} else {
+			e.kill(wordStart(e.lines[e.row], e.col), e.col)
+		}
+	case "kill-word":
+		e.save()
+		e.kill(e.col, wordEnd(e.lines[e.row], e.col))
+	case "yank":
+		e.save()
+		e.insert(e.killed)
+	case "undo":
+		e.restore()
+	case "complete":
+		e.complete()
+	case "accept-line":
+		return e.accept()
+	case "newline":
+		e.save()
+		e.insert([]rune{'\n'})
+	case "abort":
+		e.out.WriteString("^C")
+		e.leave()
+		return true, errPromptAborted
+	case "clear-screen":
+		e.out.WriteString("\x1b[H\x1b[2J")
+		e.cursor = 0
+	case "search-history":
+		return e.search()
+	case "insert-last-result":
+		if e.lastResult != nil {
+			e.save()
+			e.insert([]rune(e.lastResult()))
+		}
+	case "vi-normal-mode":
+		e.normal = true
+		e.left()
+		e.fixCol()
+	default:
+		e.bell()
+	}
+	return
+}
+
+// insert puts rs before the cursor, starting a new line at every newline
+func (e *editor) insert(rs []rune) {
+	for _, r := range rs {
+		line := e.lines[e.row]
+		if r == '\n' {
+			rest := append([]rune(nil), line[e.col:]...)
+			e.lines[e.row] = line[:e.col]
+			e.lines = append(e.lines[:e.row+1], append([][]rune{rest}, e.lines[e.row+1:]...)...)
+			e.row, e.col = e.row+1, 0
+			continue
+		}
+		e.lines[e.row] = append(line[:e.col], append([]rune{r}, line[e.col:]...)...)
+		e.col++
+	}
+}
+
+// deleteChar deletes the character under the cursor, joining the next line
+// at the end of a line
+func (e *editor) deleteChar() {
+	line := e.lines[e.row]
+	if e.col < len(line) {
+		e.lines[e.row] = append(line[:e.col], line[e.col+1:]...)
+	} else if e.row < len(e.lines)-1 {
+		e.lines[e.row] = append(line, e.lines[e.row+1]...)
+		e.lines = append(e.lines[:e.row+1], e.lines[e.row+2:]...)
+	}
+}
+
+// kill cuts the characters from to to of the current line
+func (e *editor) kill(from, to int) {
+	line := e.lines[e.row]
+	e.killed = append([]rune(nil), line[from:to]...)
+	e.killRow = false
+	e.lines[e.row] = append(line[:from], line[to:]...)
+	e.col = from
+}
+
+// left moves the cursor back a character, to the end of the previous line
+// at the start of a line
+func (e *editor) left() bool {
+	switch {
+	case e.col > 0:
+		e.col--
+	case e.row > 0 && !e.normal:
+		e.row--
+		e.col = len(e.lines[e.row])
+	default:
+		return false
+	}
+	return true
+}
+
+func (e *editor) right() bool {
+	switch {
+	case e.col < len(e.lines[e.row]):
+		e.col++
+	case e.row < len(e.lines)-1:
+		e.row, e.col = e.row+1, 0
+	default:
+		return false
+	}
+	return true
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// wordStart returns the start of the word before col
+func wordStart(line []rune, col int) int {
+	for col > 0 && !isWordRune(line[col-1]) {
+		col--
+	}
+	for col > 0 && isWordRune(line[col-1]) {
+		col--
+	}
+	return col
+}
+
+// wordEnd returns the end of the word after col
+func wordEnd(line []rune, col int) int {
+	for col < len(line) && !isWordRune(line[col]) {
+		col++
+	}
+	for col < len(line) && isWordRune(line[col]) {
+		col++
+	}
+	return col
+}
+
+// save remembers the input for undo
+func (e *editor) save() {
+	s := editState{row: e.row, col: e.col}
+	for _, l := range e.lines {
+		s.lines = append(s.lines, append([]rune(nil), l...))
+	}
+	e.undo = append(e.undo, s)
+}
+
+func (e *editor) restore() {
+	n := len(e.undo)
+	if n == 0 {
+		e.bell()
+		return
+	}
+	e.editState = e.undo[n-1]
+	e.undo = e.undo[:n-1]
+}
+
+// historyMove goes delta entries back or forth in history, skipping the
+// entries that do not start with the input written before browsing it
+func (e *editor) historyMove(delta int) {
+	if e.histPos == len(e.history) {
+		e.histNew = e.text()
+	}
+	for pos := e.histPos + delta; pos >= 0 && pos <= len(e.history); pos += delta {
+		if pos == len(e.history) {
+			e.histPos = pos
+			e.setText(e.histNew)
+			return
+		}
+		if entry := e.history[pos]; strings.HasPrefix(entry, e.histNew) && entry != e.text() {
+			e.histPos = pos
+			e.setText(entry)
+			e.fixCol()
+			return
+		}
+	}
+	e.bell()
+}
+
+// search looks for the input in history while the pattern is typed, C-r
+// going further back
+func (e *editor) search() (bool, error) {
+	e.save()
+	prompt, found, query := e.prompt, e.text(), []rune{}
+	pos := len(e.history) - 1
+	find := func(from int) {
+		for p := from; p >= 0; p-- {
+			if strings.Contains(e.history[p], string(query)) {
+				pos, found = p, e.history[p]
+				return
+			}
+		}
+		e.bell()
+	}
+
+	for {
+		e.prompt = fmt.Sprintf("(search)`%s': ", string(query))
+		e.setText(found)
+		e.render()
+
+		key, err := e.readKey()
+		if err != nil {
+			return false, err
+		}
+		switch key {
+		case "C-r":
+			find(pos - 1)
+		case "backspace", "C-h":
+			if len(query) > 0 {
+				query = query[:len(query)-1]
+				find(len(e.history) - 1)
+			}
+		case "C-g", "C-c", "esc":
+			e.prompt = prompt
+			e.restore()
+			return false, nil
+		default:
+			if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) {
+				query = append(query, r[0])
+				find(pos)
+				continue
+			}
+			e.prompt = prompt
+			e.histPos = pos
+			if key == "" {
+				return false, nil
+			}
+			return e.press(key)
+		}
+	}
+}
+
+// complete completes the word before the cursor, and lists the candidates
+// when tab is pressed again without anything to add
+func (e *editor) complete() {
+	if e.completer == nil {
+		return
+	}
+	e.tabs++
+	line := string(e.lines[e.row])
+	pos := len(string(e.lines[e.row][:e.col]))
+	head, completions, tail := e.completer(line, pos)
+	if len(completions) == 0 {
+		e.bell()
+		return
+	}
+
+	prefix := completions[0]
+	for _, c := range completions[1:] {
+		for !strings.HasPrefix(c, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	if len(head+prefix) > pos || len(completions) == 1 {
+		e.save()
+		e.lines[e.row] = []rune(head + prefix + tail)
+		e.col = len([]rune(head + prefix))
+		e.tabs = 0
+		return
+	}
+	if e.tabs > 1 {
+		e.list(completions)
+	} else {
+		e.bell()
+	}
+}
+
+// list shows items in columns below the input
+func (e *editor) list(items []string) {
+	cols := e.width()
+	width := 0
+	for _, item := range items {
+		if w := runewidth.StringWidth(item) + 2; w > width {
+			width = w
+		}
+	}
+	perRow := cols / width
+	if perRow == 0 {
+		perRow = 1
+	}
+
+	e.below()
+	for i, item := range items {
+		e.out.WriteString(item)
+		if (i+1)%perRow == 0 || i == len(items)-1 {
+			e.out.WriteString("\r\n")
+		} else {
+			e.out.WriteString(strings.Repeat(" ", width-runewidth.StringWidth(item)))
+		}
+	}
+	e.cursor = 0
+}
+
+func (e *editor) accept() (bool, error) {
+	if e.pasted != nil {
+		if text := e.pasted(); text != "" {
+			e.save()
+			e.insert([]rune(text))
+		}
+	}
+	if e.incomplete != nil && e.incomplete(e.text()) {
+		e.save()
+		e.insert([]rune{'\n'})
+		return false, nil
+	}
+	e.row = len(e.lines) - 1
+	e.col = len(e.lines[e.row])
+	e.render()
+	e.leave()
+	return true, nil
+}
+
+// restoreTerminal puts the terminal back in the mode it had before edit,
+// for gop to end while it is editing
+func (e *editor) restoreTerminal() {
+	if e.fd >= 0 && e.cooked != nil {
+		term.Restore(e.fd, e.cooked)
+	}
+}
+
+// runLine runs a command line bound to a key, and draws the input again
+// below its output
+func (e *editor) runLine(line string) {
+	if e.run == nil {
+		return
+	}
+	e.leave()
+	term.Restore(e.fd, e.cooked)
+	e.run(line)
+	term.MakeRaw(e.fd)
+	e.cursor = 0
+}
+
+func (e *editor) width() int {
+	if w, _, err := term.GetSize(e.fd); err == nil && w > 0 {
+		return w
+	}
+	return 80
+}
+
+// linePrompts returns the prompt of each line: the prompt itself for the
+// first, and for the others the continuation prompt, indented to the depth
+// the line belongs at unless it brings its own indentation
+func (e *editor) linePrompts() []string {
+	prompts := []string{e.prompt}
+	src := string(e.lines[0])
+	for _, l := range e.lines[1:] {
+		src += "\n" + string(l)
+		_, last, lastInRaw, _ := indentation(src)
+		if lastInRaw || (len(l) > 0 && unicode.IsSpace(l[0])) {
+			prompts = append(prompts, promptContinue)
+		} else {
+			prompts = append(prompts, promptContinue+strings.Repeat(indent, last))
+		}
+	}
+	return prompts
+}
+
+func textWidth(rs []rune) (n int) {
+	for _, r := range rs {
+		if r == '\t' {
+			n += tabWidth
+		} else {
+			n += runewidth.RuneWidth(r)
+		}
+	}
+	return
+}
+
+// render draws the input over what was drawn before, and puts the cursor
+// in place
+func (e *editor) render() {
+	cols := e.width()
+	prompts := e.linePrompts()
+
+	if e.cursor > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dA", e.cursor)
+	}
+	e.out.WriteString("\r\x1b[J")
+
+	rows, cursorRow, cursorCol := 0, 0, 0
+	for i, l := range e.lines {
+		if i > 0 {
+			e.out.WriteString("\r\n")
+		}
+		e.out.WriteString(prompts[i])
+		e.out.WriteString(strings.Replace(string(l), "\t", strings.Repeat(" ", tabWidth), -1))
+
+		width := runewidth.StringWidth(prompts[i])
+		if i == e.row {
+			x := width + textWidth(l[:e.col])
+			cursorRow, cursorCol = rows+x/cols, x%cols
+		}
+		if width += textWidth(l); width > 0 {
+			rows += (width + cols - 1) / cols
+		} else {
+			rows++
+		}
+	}
+
+	// the terminal cursor is on the last row now, or waits at its end to
+	// start a new one
+	last := rows - 1
+	if cursorRow > last {
+		e.out.WriteString("\r\n")
+		last++
+	}
+	if up := last - cursorRow; up > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dA", up)
+	}
+	e.out.WriteString("\r")
+	if cursorCol > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dC", cursorCol)
+	}
+	e.cursor, e.rows = cursorRow, last+1
+	e.out.Flush()
+}
+
+// below moves the cursor to a new line below the input
+func (e *editor) below() {
+	if down := e.rows - 1 - e.cursor; down > 0 {
+		fmt.Fprintf(e.out, "\x1b[%dB", down)
+	}
+	e.out.WriteString("\r\n")
+}
+
+// leave moves the cursor below the input, for the output that follows it
+func (e *editor) leave() {
+	e.below()
+	e.cursor = 0
+	e.out.Flush()
+}
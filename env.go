@@ -0,0 +1,188 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// metaPrefix starts the lines ahead of the program in session files and
+// templates, which hold the arguments, environment and directory it runs
+// with, and its build settings
+const metaPrefix = "//gop:"
+
+func (w *Workspace) metadata() string {
+	meta := ""
+	if w.args != "" {
+		meta += metaPrefix + "args " + w.args + "\n"
+	}
+	for _, change := range w.env {
+		meta += metaPrefix + "env " + strconv.Quote(change) + "\n"
+	}
+	if w.dir != "" {
+		meta += metaPrefix + "dir " + strconv.Quote(w.dir) + "\n"
+	}
+	if settings := w.build.String(); settings != "" {
+		meta += metaPrefix + "build " + settings + "\n"
+	}
+	return meta
+}
+
+// readMetadata takes the metadata off the start of src, and returns the
+// rest. Metadata found replaces the arguments, environment, directory and
+// build settings of w.
+func (w *Workspace) readMetadata(src string) (string, error) {
+	if strings.HasPrefix(src, metaPrefix) {
+		w.args, w.env, w.dir, w.build = "", nil, "", buildSettings{}
+	}
+	for strings.HasPrefix(src, metaPrefix) {
+		line := src
+		src = ""
+		if p := strings.Index(line, "\n"); p != -1 {
+			line, src = line[:p], line[p+1:]
+		}
+		key, value := cutField(strings.TrimPrefix(line, metaPrefix))
+		switch key {
+		case "args":
+			w.args = value
+			continue
+		case "build":
+			words, err := splitWords(value)
+			if err == nil {
+				err = w.build.apply(words)
+			}
+			if err != nil {
+				return "", fmt.Errorf("%s: %v", line, err)
+			}
+			continue
+		}
+		value, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("%s: %v", line, err)
+		}
+		switch key {
+		case "env":
+			w.env = setEnv(w.env, value)
+		case "dir":
+			w.dir = value
+		}
+	}
+	return src, nil
+}
+
+func envKey(change string) string {
+	if p := strings.Index(change, "="); p != -1 {
+		return change[:p]
+	}
+	return change
+}
+
+// removeEnv returns env without key
+func removeEnv(env []string, key string) []string {
+	kept := []string{}
+	for _, kv := range env {
+		if envKey(kv) != key {
+			kept = append(kept, kv)
+		}
+	}
+	return kept
+}
+
+// setEnv adds change, KEY=value to set KEY or KEY to unset it, to the
+// changes in env
+func setEnv(env []string, change string) []string {
+	return append(removeEnv(env, envKey(change)), change)
+}
+
+// childEnv returns the environment of gop with changes made to it
+func childEnv(changes ...[]string) []string {
+	env := os.Environ()
+	for _, list := range changes {
+		for _, change := range list {
+			env = removeEnv(env, envKey(change))
+			if strings.Contains(change, "=") {
+				env = append(env, change)
+			}
+		}
+	}
+	return env
+}
+
+// expandHome replaces a leading ~ in path by $HOME
+func expandHome(path string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		return filepath.Join(os.Getenv("HOME"), path[1:])
+	}
+	return path
+}
+
+// cmdEnv lists the changes made to the environment of the program, makes
+// the KEY=value changes given, or unsets the keys after -u
+func cmdEnv(w *Workspace, args string) error {
+	words, err := splitWords(args)
+	if err != nil {
+		return err
+	}
+	if len(words) == 0 {
+		for _, change := range w.env {
+			if strings.Contains(change, "=") {
+				fmt.Println(change)
+			} else {
+				fmt.Println("-u " + change)
+			}
+		}
+		return nil
+	}
+
+	env := w.env
+	if words[0].plain && words[0].text == "-u" {
+		if len(words) == 1 {
+			return errors.New("no key to unset")
+		}
+		for _, word := range words[1:] {
+			env = setEnv(env, word.text)
+		}
+	} else {
+		for _, word := range words {
+			if !envWord.MatchString(word.text) {
+				return errors.New("not KEY=value: " + word.text)
+			}
+			env = setEnv(env, word.text)
+		}
+	}
+	w.env = env
+	return nil
+}
+
+// cmdCd shows or changes the directory the program runs in
+func cmdCd(w *Workspace, args string) error {
+	dir := w.dir
+	if dir == "" {
+		wd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		dir = wd
+	}
+	if args == "" {
+		fmt.Println(dir)
+		return nil
+	}
+
+	path := expandHome(args)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return errors.New(args + " is not a directory")
+	}
+	w.dir = filepath.Clean(path)
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"go/token"
+	"reflect"
+	"testing"
+)
+
+func TestSetEnv(t *testing.T) {
+	for _, c := range []struct {
+		env    []string
+		change string
+		want   []string
+	}{
+		{nil, "A=1", []string{"A=1"}},
+		{[]string{"A=1", "B=2"}, "A=3", []string{"B=2", "A=3"}},
+		{[]string{"A=1", "B=2"}, "A", []string{"B=2", "A"}},
+		{[]string{"AB=1"}, "A=2", []string{"AB=1", "A=2"}},
+		{[]string{"A=1"}, "A=", []string{"A="}},
+	} {
+		env := append([]string(nil), c.env...)
+		if got := setEnv(env, c.change); !reflect.DeepEqual(got, c.want) {
+			t.Errorf("setEnv(%q, %q) = %q; want %q", c.env, c.change, got, c.want)
+		}
+		if !reflect.DeepEqual(env, c.env) && len(c.env) > 0 {
+			t.Errorf("setEnv(%q, %q) changed its argument to %q", c.env, c.change, env)
+		}
+	}
+}
+
+func TestMetadata(t *testing.T) {
+	w := &Workspace{
+		args:  `-v 'a b'`,
+		env:   []string{"A=1", `B=x "y"`, "C"},
+		dir:   "/tmp/a dir",
+		build: buildSettings{tags: "x y", race: true},
+	}
+	c := &Workspace{files: token.NewFileSet()}
+	src, err := c.readMetadata(w.metadata() + "package main\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src != "package main\n" {
+		t.Errorf("source after metadata %q", src)
+	}
+	if c.args != w.args || !reflect.DeepEqual(c.env, w.env) || c.dir != w.dir || c.build.String() != w.build.String() {
+		t.Errorf("metadata read back as %q %q %q %q; want %q %q %q %q",
+			c.args, c.env, c.dir, c.build, w.args, w.env, w.dir, w.build)
+	}
+
+	// a template without metadata keeps the settings
+	src, err = c.readMetadata("package main\n")
+	if err != nil || src != "package main\n" || c.args != w.args {
+		t.Errorf("readMetadata without metadata = %q, %v, args %q", src, err, c.args)
+	}
+
+	if _, err = c.readMetadata(metaPrefix + "env A=1\n"); err == nil {
+		t.Error("unquoted env read")
+	}
+}
@@ -0,0 +1,493 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/format"
+	"go/parser"
+	"go/printer"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// fence returns a backtick fence longer than any backtick run in text
+func fence(text string) string {
+	max, cur := 0, 0
+	for _, r := range text {
+		if r == '`' {
+			cur++
+			if cur > max {
+				max = cur
+			}
+		} else {
+			cur = 0
+		}
+	}
+	if max < 3 {
+		return "```"
+	}
+	return strings.Repeat("`", max+1)
+}
+
+func writeBlock(buf *bytes.Buffer, lang, text string) {
+	text = strings.TrimRight(text, "\n")
+	f := fence(text)
+	fmt.Fprintf(buf, "%s%s\n%s\n%s\n\n", f, lang, text, f)
+}
+
+// markdown renders the session: every step as a go block followed by its
+// output, and the full source at the end
+func (w *Workspace) markdown() string {
+	buf := new(bytes.Buffer)
+	buf.WriteString("# gop session\n\n")
+
+	for pos, s := range w.transcript {
+		fmt.Fprintf(buf, "## Step %d\n\n", pos+1)
+		writeBlock(buf, "go", s.input)
+		if s.output != "" {
+			writeBlock(buf, "text", s.output)
+		}
+	}
+
+	buf.WriteString("## Source\n\n")
+	writeBlock(buf, "go", w.source(false, false, false))
+	return buf.String()
+}
+
+// addStep adds s, made after codes, to the transcript. The output of a
+// step that ran is what its run wrote past the output of the last run of
+// codes, whose program it only added to.
+func (w *Workspace) addStep(s *step, codes []interface{}) {
+	if s.ran {
+		s.output = s.stdout + s.stderr
+		for i := len(w.transcript) - 1; i >= 0; i-- {
+			if prev := w.transcript[i]; prev.ran && !prev.custom && sameEntries(prev.codes, codes) {
+				s.output = strings.TrimPrefix(s.stdout, prev.stdout) + strings.TrimPrefix(s.stderr, prev.stderr)
+				break
+			}
+		}
+	}
+	w.transcript = append(w.transcript, s)
+}
+
+// lastRun returns the last step of the transcript that ran, or nil. Custom
+// runs are left out unless custom.
+func (w *Workspace) lastRun(custom bool) *step {
+	for i := len(w.transcript) - 1; i >= 0; i-- {
+		if s := w.transcript[i]; s.ran && (custom || !s.custom) {
+			return s
+		}
+	}
+	return nil
+}
+
+// pruneTranscript drops the steps that added any of entries
+func (w *Workspace) pruneTranscript(entries []interface{}) {
+	kept := []*step{}
+	for _, s := range w.transcript {
+		drop := false
+		for _, entry := range entries {
+			for _, added := range s.added {
+				drop = drop || added == entry
+			}
+		}
+		if !drop {
+			kept = append(kept, s)
+		}
+	}
+	w.transcript = kept
+}
+
+// newEntries returns the entries of after that are not in before
+func newEntries(before, after []interface{}) []interface{} {
+	added := []interface{}{}
+	for _, entry := range after {
+		found := false
+		for _, old := range before {
+			found = found || old == entry
+		}
+		if !found {
+			added = append(added, entry)
+		}
+	}
+	return added
+}
+
+// localModule returns the path and directory of the module gop was started
+// in, so that its packages can be replaced in an exported module
+func localModule() (path, dir string) {
+	out, err := exec.Command(conf.Go, "list", "-m", "-f", "{{.Path}}\t{{.Dir}}").Output()
+	if err != nil {
+		return
+	}
+	fields := strings.SplitN(strings.TrimSpace(string(out)), "\t", 2)
+	if len(fields) != 2 || fields[1] == "" {
+		return
+	}
+	return fields[0], fields[1]
+}
+
+func (w *Workspace) importPaths() (paths []string) {
+	for _, pkg := range w.pkgs {
+		v := pkg.(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
+		path, _ := strconv.Unquote(v.Path.Value)
+		paths = append(paths, path)
+	}
+	return
+}
+
+func goCommand(dir string, args ...string) error {
+	cmd := exec.Command(conf.Go, args...)
+	cmd.Dir = dir
+	stdoutStderr, err := cmd.CombinedOutput()
+	if err != nil && len(stdoutStderr) > 0 {
+		err = fmt.Errorf("go %s: %s", strings.Join(args, " "), stdoutStderr)
+	}
+	return err
+}
+
+const mainTestTmpl = `package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	expected := %s
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan []byte)
+	go func() {
+		out, _ := ioutil.ReadAll(r)
+		done <- out
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	os.Args = append(os.Args[:1], %s...)
+	main()
+	os.Stdout = stdout
+	w.Close()
+
+	out := <-done
+	if string(out) != expected {
+		t.Errorf("output:\n%%s\nexpected:\n%%s", out, expected)
+	}
+}
+`
+
+// exportModule writes the session into dir, which must be new or empty, as
+// a module that builds on its own: main.go, a tidied go.mod and, with
+// withTest, a main_test.go that checks the program still prints what it
+// prints now
+func exportModule(w *Workspace, dir string, withTest bool) (err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	if entries, err := ioutil.ReadDir(dir); err == nil && len(entries) > 0 {
+		return errors.New(dir + " is not empty")
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return
+	}
+
+	src, err := format.Source([]byte(w.source(false, false, false)))
+	if err != nil {
+		return
+	}
+	if err = ioutil.WriteFile(filepath.Join(dir, "main.go"), src, 0644); err != nil {
+		return
+	}
+
+	name := regexp.MustCompile(`[^\w.\-]+`).ReplaceAllString(filepath.Base(dir), "")
+	if name == "" {
+		name = "gop"
+	}
+	if err = goCommand(dir, "mod", "init", name); err != nil {
+		return
+	}
+
+	if modPath, modDir := localModule(); modPath != "" && modDir != dir {
+		for _, path := range w.importPaths() {
+			if path == modPath || strings.HasPrefix(path, modPath+"/") {
+				err = goCommand(dir, "mod", "edit",
+					"-require="+modPath+"@v0.0.0",
+					"-replace="+modPath+"="+modDir)
+				if err != nil {
+					return
+				}
+				break
+			}
+		}
+	}
+
+	if err = goCommand(dir, "mod", "tidy"); err != nil {
+		return
+	}
+
+	if !withTest {
+		return goCommand(dir, "build", "-o", os.DevNull, ".")
+	}
+
+	args := w.runArgs()
+	cmd := exec.Command(conf.Go, append([]string{"run", "."}, args...)...)
+	cmd.Dir = dir
+	cmd.Stderr = new(bytes.Buffer)
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("go run: %v\n%s", err, cmd.Stderr)
+	}
+
+	quoted := []string{}
+	for _, arg := range args {
+		quoted = append(quoted, strconv.Quote(arg))
+	}
+	test := fmt.Sprintf(mainTestTmpl, strconv.Quote(string(out)), "[]string{"+strings.Join(quoted, ", ")+"}")
+	return ioutil.WriteFile(filepath.Join(dir, "main_test.go"), []byte(test), 0644)
+}
+
+// declNames returns the package level names declared by def
+func declNames(def interface{}) (names []string) {
+	switch v := def.(type) {
+	case *ast.FuncDecl:
+		if v.Recv == nil {
+			names = append(names, v.Name.Name)
+		}
+	case *ast.GenDecl:
+		for _, spec := range v.Specs {
+			switch s := spec.(type) {
+			case *ast.TypeSpec:
+				names = append(names, s.Name.Name)
+			case *ast.ValueSpec:
+				for _, name := range s.Names {
+					names = append(names, name.Name)
+				}
+			}
+		}
+	}
+	return
+}
+
+// recvName returns the receiver type name of a method, or "" for others
+func recvName(def interface{}) string {
+	v, ok := def.(*ast.FuncDecl)
+	if !ok || v.Recv == nil || len(v.Recv.List) == 0 {
+		return ""
+	}
+	typ := v.Recv.List[0].Type
+	if star, ok := typ.(*ast.StarExpr); ok {
+		typ = star.X
+	}
+	if index, ok := typ.(*ast.IndexExpr); ok {
+		typ = index.X
+	}
+	if index, ok := typ.(*ast.IndexListExpr); ok {
+		typ = index.X
+	}
+	if ident, ok := typ.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+func addIdents(used map[string]bool, node interface{}) {
+	ast.Inspect(node.(ast.Node), func(n ast.Node) bool {
+		if ident, ok := n.(*ast.Ident); ok {
+			used[ident.Name] = true
+		}
+		return true
+	})
+}
+
+// usedDefs returns the defs, in workspace order, that codes depend on, and
+// the names referenced by codes and those defs
+func (w *Workspace) usedDefs(codes []interface{}) (defs []interface{}, used map[string]bool) {
+	used = map[string]bool{}
+	for _, code := range codes {
+		addIdents(used, code)
+	}
+
+	picked := make([]bool, len(w.defs))
+	for changed := true; changed; {
+		changed = false
+		for pos, def := range w.defs {
+			if picked[pos] {
+				continue
+			}
+			need := used[recvName(def)]
+			for _, name := range declNames(def) {
+				if used[name] {
+					need = true
+				}
+			}
+			if need {
+				picked[pos] = true
+				addIdents(used, def)
+				changed = true
+			}
+		}
+	}
+
+	for pos, def := range w.defs {
+		if picked[pos] {
+			defs = append(defs, def)
+		}
+	}
+	return
+}
+
+// importName returns the name of the package spec imports into a file of
+// dir, which is not always the last element of its path, as in
+// gopkg.in/yaml.v2
+func importName(spec *ast.ImportSpec, dir string) string {
+	if spec.Name != nil {
+		return spec.Name.Name
+	}
+	path, _ := strconv.Unquote(spec.Path.Value)
+	if p, err := build.Import(path, dir, 0); err == nil && p.Name != "" {
+		return p.Name
+	}
+	return filepath.Base(path)
+}
+
+// checkExampleName tells whether go vet takes Example<name> as an example
+// of pkg: name is empty, a _suffix, or an exported name declared in pkg
+// followed by any _Method and _suffix
+func checkExampleName(pkg *build.Package, name string) error {
+	if name == "" {
+		return nil
+	}
+	parts := strings.Split(name, "_")
+	if parts[0] == "" {
+		if len(parts) == 2 && parts[1] != "" && !unicode.IsUpper([]rune(parts[1])[0]) {
+			return nil
+		}
+		return errors.New("example suffix must start with _ and a lower case letter: " + name)
+	}
+	if !ast.IsExported(parts[0]) {
+		return errors.New("example name must start with an exported name: " + name)
+	}
+	fset := token.NewFileSet()
+	for _, base := range pkg.GoFiles {
+		f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, base), nil, 0)
+		if err != nil {
+			return err
+		}
+		for _, decl := range f.Decls {
+			for _, declared := range declNames(decl) {
+				if declared == parts[0] {
+					return nil
+				}
+			}
+		}
+	}
+	return fmt.Errorf("%s is not declared in package %s", parts[0], pkg.Name)
+}
+
+// checkExampleUnused tells whether the test files of pkg, other than
+// skipped, leave Example<name> free
+func checkExampleUnused(pkg *build.Package, name, skipped string) error {
+	fset := token.NewFileSet()
+	for _, base := range append(append([]string(nil), pkg.TestGoFiles...), pkg.XTestGoFiles...) {
+		if base == skipped {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(pkg.Dir, base), nil, 0)
+		if err != nil {
+			return err
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && fn.Name.Name == "Example"+name {
+				return fmt.Errorf("Example%s is already declared in %s", name, base)
+			}
+		}
+	}
+	return nil
+}
+
+// exportExample writes the code of the last run, with the defs and imports
+// it uses, as func Example<name> into a _test.go file of the package in dir,
+// with the stdout of that run as its Output comment. An existing file is
+// only replaced with overwrite.
+func exportExample(w *Workspace, dir, name string, overwrite bool) (file string, err error) {
+	last := w.lastRun(false)
+	if last == nil {
+		err = errors.New("nothing has been run yet")
+		return
+	}
+
+	pkg, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return
+	}
+	if err = checkExampleName(pkg, name); err != nil {
+		return
+	}
+
+	base := "example_test.go"
+	if name != "" {
+		base = "example_" + strings.ToLower(strings.Trim(name, "_")) + "_test.go"
+	}
+	file = filepath.Join(dir, base)
+	if _, err = os.Stat(file); err == nil && !overwrite {
+		err = errors.New(file + " exists, use -f to replace it")
+		return
+	}
+	if err = checkExampleUnused(pkg, name, base); err != nil {
+		return
+	}
+
+	defs, used := w.usedDefs(last.codes)
+
+	src := new(bytes.Buffer)
+	fmt.Fprintf(src, "package %s_test\n\n", pkg.Name)
+	for _, v := range append(append([]interface{}(nil), w.pkgs...), w.pkgsNotimport...) {
+		name := importName(v.(*ast.GenDecl).Specs[0].(*ast.ImportSpec), dir)
+		if name == "_" || name == "." || used[name] {
+			printer.Fprint(src, w.files, v)
+			src.WriteString("\n")
+		}
+	}
+	src.WriteString("\n")
+	for _, def := range defs {
+		printer.Fprint(src, w.files, def)
+		src.WriteString("\n\n")
+	}
+
+	fmt.Fprintf(src, "func Example%s() {\n", name)
+	for _, code := range last.codes {
+		printer.Fprint(src, w.files, code)
+		src.WriteString("\n")
+	}
+	src.WriteString("// Output:\n")
+	if out := strings.TrimSpace(last.stdout); out != "" {
+		for _, line := range strings.Split(out, "\n") {
+			src.WriteString("// " + line + "\n")
+		}
+	}
+	src.WriteString("}\n")
+
+	bs, err := format.Source(src.Bytes())
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(file, bs, 0644)
+	return
+}
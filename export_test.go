@@ -0,0 +1,41 @@
+package main
+
+import (
+	"go/ast"
+	"testing"
+)
+
+func TestAddStep(t *testing.T) {
+	a, b, d := &ast.Ident{Name: "a"}, &ast.Ident{Name: "b"}, &ast.Ident{Name: "d"}
+	w := &Workspace{defs: []interface{}{d}, codes: []interface{}{a, b}}
+	w.addStep(&step{input: "a", codes: []interface{}{a}, added: []interface{}{a}, stdout: "1\n", ran: true}, nil)
+	w.addStep(&step{input: "d", codes: []interface{}{a}, added: []interface{}{d}}, []interface{}{a})
+	w.addStep(&step{input: "b", codes: []interface{}{a, b}, added: []interface{}{b}, stdout: "1\n2\n", ran: true}, []interface{}{a})
+	w.transcript = append(w.transcript, &step{input: "// :run x", codes: []interface{}{a, b}, stdout: "1\nx\n", output: "1\nx\n", ran: true, custom: true})
+	w.addStep(&step{input: "c", codes: []interface{}{a, b}, stdout: "1\n2\n3\n", ran: true}, []interface{}{a, b})
+
+	for i, want := range []string{"1\n", "", "2\n", "1\nx\n", "3\n"} {
+		if out := w.transcript[i].output; out != want {
+			t.Errorf("output of step %d = %q; want %q", i+1, out, want)
+		}
+	}
+	if last := w.lastRun(true); last == nil || last.input != "c" {
+		t.Errorf("last run %v; want step c", last)
+	}
+	w.transcript = w.transcript[:4]
+	if last := w.lastRun(false); last == nil || last.input != "b" {
+		t.Errorf("last run %v; want step b", last)
+	}
+	if last := w.lastRun(true); last == nil || last.input != "// :run x" {
+		t.Errorf("last run %v; want the run with x", last)
+	}
+
+	removeByIndex(w, "d0")
+	if len(w.transcript) != 3 || w.transcript[1].input != "b" {
+		t.Errorf("%d steps after removing d0; want a, b and the run", len(w.transcript))
+	}
+	removeByIndex(w, "c1")
+	if last := w.lastRun(false); len(w.transcript) != 2 || last == nil || last.input != "a" {
+		t.Errorf("%d steps after removing c1; want a and the run", len(w.transcript))
+	}
+}
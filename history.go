@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// historyHeader starts a history file whose entries are escaped, so that
+// an entry may span several lines. Files without it hold one plain line
+// per entry.
+const historyHeader = "#gop-history 2"
+
+func escapeHistory(entry string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", `\r`).Replace(entry)
+}
+
+func unescapeHistory(line string) string {
+	buf := new(bytes.Buffer)
+	for i := 0; i < len(line); i++ {
+		if line[i] != '\\' || i == len(line)-1 {
+			buf.WriteByte(line[i])
+			continue
+		}
+		i++
+		switch line[i] {
+		case 'n':
+			buf.WriteByte('\n')
+		case 'r':
+			buf.WriteByte('\r')
+		default:
+			buf.WriteByte(line[i])
+		}
+	}
+	return buf.String()
+}
+
+// readHistoryEntries reads the entries of a history file
+func readHistoryEntries(r io.Reader) (entries []string, err error) {
+	s := bufio.NewScanner(r)
+	s.Buffer(nil, 1024*1024)
+	escaped := false
+	for n := 0; s.Scan(); n++ {
+		line := s.Text()
+		if n == 0 && line == historyHeader {
+			escaped = true
+			continue
+		}
+		if escaped {
+			line = unescapeHistory(line)
+		}
+		entries = append(entries, line)
+	}
+	err = s.Err()
+	return
+}
+
+func writeHistoryEntries(w io.Writer, entries []string) error {
+	buf := bufio.NewWriter(w)
+	buf.WriteString(historyHeader + "\n")
+	for _, entry := range entries {
+		buf.WriteString(escapeHistory(entry) + "\n")
+	}
+	return buf.Flush()
+}
+
+// historyEntries returns the entries in history, oldest first
+func (cl *contLiner) historyEntries() []string {
+	return append([]string(nil), cl.ed.history...)
+}
+
+func (cl *contLiner) readHistory(r io.Reader) error {
+	entries, err := readHistoryEntries(r)
+	for _, entry := range entries {
+		cl.addHistory(entry)
+	}
+	return err
+}
+
+// loadHistory reads the history file
+func (cl *contLiner) loadHistory(file string) error {
+	bs, err := readFileLocked(file)
+	if err != nil {
+		return err
+	}
+	return cl.readHistory(bytes.NewReader(bs))
+}
+
+// saveHistory adds the entries accepted since gop started to the history
+// file, as another gop may have written it meanwhile, and keeps at most the
+// last size entries
+func (cl *contLiner) saveHistory(file string, size int) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	unlock, err := lockFile(file, true)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	var entries []string
+	if f, err := os.Open(file); err == nil {
+		entries, err = readHistoryEntries(f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	for _, entry := range cl.added {
+		entries = appendHistory(entries, entry)
+	}
+	if size >= 0 && len(entries) > size {
+		entries = entries[len(entries)-size:]
+	}
+	buf := new(bytes.Buffer)
+	if err = writeHistoryEntries(buf, entries); err != nil {
+		return err
+	}
+	return replaceFile(file, buf.Bytes())
+}
+
+// historyFile returns the file history is kept in. With history_per_project
+// every module, or directory outside of modules, has a file of its own.
+func historyFile() string {
+	if !conf.HistoryPerProject {
+		return filepath.Join(home, "history")
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return filepath.Join(home, "history")
+	}
+	if out, err := exec.Command(conf.Go, "env", "GOMOD").Output(); err == nil {
+		if gomod := strings.TrimSpace(string(out)); gomod != "" && gomod != os.DevNull {
+			dir = filepath.Dir(gomod)
+		}
+	}
+	sum := sha1.Sum([]byte(dir))
+	name := fmt.Sprintf("%s-%x", filepath.Base(dir), sum[:4])
+	return filepath.Join(home, "history.d", name)
+}
+
+// appendHistory appends entry to history, dropping an earlier copy of it
+// when history_dedup is set
+func appendHistory(history []string, entry string) []string {
+	if conf.HistoryDedup {
+		for pos, e := range history {
+			if e == entry {
+				history = append(history[:pos], history[pos+1:]...)
+				break
+			}
+		}
+	}
+	return append(history, entry)
+}
+
+func (cl *contLiner) addHistory(entry string) {
+	cl.ed.history = appendHistory(cl.ed.history, entry)
+}
+
+func printHistoryEntry(pos int, entry string) {
+	fmt.Printf("%d\t%s\n", pos, strings.Replace(entry, "\n", "\n\t", -1))
+}
+
+// cmdHistory lists the last n entries, lists the entries matching a
+// pattern, or runs entry n again
+func cmdHistory(w *Workspace, args string) error {
+	if rl == nil {
+		return errors.New("no history")
+	}
+	entries := rl.historyEntries()
+
+	sub, subArgs := args, ""
+	if p := strings.IndexAny(args, " \t"); p != -1 {
+		sub, subArgs = args[:p], strings.TrimSpace(args[p+1:])
+	}
+
+	if _, err := strconv.Atoi(sub); err == nil {
+		sub, subArgs = "list", sub
+	}
+
+	switch sub {
+	case "", "list":
+		from := 0
+		if subArgs != "" {
+			n, err := strconv.Atoi(subArgs)
+			if err != nil {
+				return fmt.Errorf("%s not integer", subArgs)
+			}
+			if n < len(entries) {
+				from = len(entries) - n
+			}
+		}
+		for pos := from; pos < len(entries); pos++ {
+			printHistoryEntry(pos, entries[pos])
+		}
+	case "grep":
+		re, err := regexp.Compile(subArgs)
+		if err != nil {
+			return err
+		}
+		for pos, entry := range entries {
+			if re.MatchString(entry) {
+				printHistoryEntry(pos, entry)
+			}
+		}
+	case "run":
+		pos, err := strconv.Atoi(subArgs)
+		if err != nil {
+			return fmt.Errorf("%s not integer", subArgs)
+		}
+		if pos < 0 || pos >= len(entries) {
+			return fmt.Errorf("%d out of range", pos)
+		}
+		entry := entries[pos]
+		fmt.Println(strings.Replace(entry, "\n", "\n"+promptContinue, -1))
+		rl.buffer = entry
+
+		notComplete, err := dispatch(w, entry)
+		if err == nil && notComplete {
+			err = errors.New("incomplete input")
+		}
+		return err
+	default:
+		return errors.New("unknown history command: " + sub)
+	}
+	return nil
+}
@@ -1,15 +1,10 @@
 package main
 
 import (
-	"bytes"
-	"fmt"
-	"io"
-	"regexp"
-
+	goscanner "go/scanner"
+	"go/token"
+	"os"
 	"strings"
-	"text/scanner"
-
-	"github.com/peterh/liner"
 )
 
 const (
@@ -18,98 +13,131 @@ const (
 )
 
 type contLiner struct {
-	*liner.State
+	ed     *editor
+	paste  *pasteReader
 	buffer string
-	depth  int
+	// added holds the entries accepted since gop started
+	added []string
 }
 
+// rl is the line reader of the REPL, nil until main has set it up
+var rl *contLiner
+
 func newContLiner() *contLiner {
-	rl := liner.NewLiner()
-	rl.SetCtrlCAborts(true)
-	return &contLiner{State: rl}
+	paste := newPasteReader()
+	tty := os.Stdin
+	if paste != nil {
+		tty = paste.in
+	}
+	ed := newEditor(tty)
+	ed.pasted = paste.take
+	return &contLiner{ed: ed, paste: paste}
 }
 
-func (cl *contLiner) promptString(p string) string {
-	if cl.buffer != "" {
-		return promptContinue + strings.Repeat(indent, cl.depth)
+func (cl *contLiner) Close() error {
+	cl.ed.restoreTerminal()
+	if cl.paste != nil {
+		cl.paste.Close()
 	}
+	return nil
+}
 
-	return p
+func (cl *contLiner) SetWordCompleter(f func(line string, pos int) (string, []string, string)) {
+	cl.ed.completer = f
 }
 
+// promptLine reads a single line, or the text pasted into it
+func (cl *contLiner) promptLine(p string) (string, error) {
+	incomplete := cl.ed.incomplete
+	cl.ed.incomplete = nil
+	defer func() { cl.ed.incomplete = incomplete }()
+	return cl.ed.edit(p, "", false)
+}
+
+// Prompt reads an input. After an input found to be incomplete, it goes on
+// editing it with a new line added.
 func (cl *contLiner) Prompt(p string) (string, error) {
-	line, err := cl.State.Prompt(cl.promptString(p))
+	text := ""
+	if cl.buffer != "" {
+		text = cl.buffer + "\n"
+	}
+	text, err := cl.ed.edit(p, text, cl.buffer != "")
 	switch err {
-	case io.EOF:
-		println()
-	case liner.ErrPromptAborted:
-		if cl.buffer != "" {
+	case errPromptAborted:
+		if strings.TrimSpace(text) != "" {
+			cl.buffer = text
 			cl.Accepted()
 		} else {
+			cl.buffer = ""
 			println("(^D to quit)")
 		}
 		err = nil
-	//case liner.ErrPromptCtrlZ:
-	//	go exec.Command("kill", "-SIGTSTP", strconv.Itoa(os.Getpid())).Run()
-	//	err = nil
 	case nil:
-		if cl.buffer != "" {
-			cl.buffer += "\n" + line
-		} else {
-			cl.buffer = line
-		}
+		cl.buffer = text
 	}
 
 	return cl.buffer, err
 }
 
 func (cl *contLiner) Accepted() {
-	cl.buffer = regexp.MustCompile(`\n+`).ReplaceAllString(cl.buffer, "\n")
-
-	if p := strings.Index(cl.buffer, "\n"); p != -1 {
-		cl.buffer = cl.buffer[:p] + cl.buffer[p+1:]
-	}
-	if p := strings.LastIndex(cl.buffer, "\n"); p != -1 {
-		cl.buffer = cl.buffer[:p] + cl.buffer[p+1:]
+	if entry := strings.Trim(cl.buffer, "\n"); strings.TrimSpace(entry) != "" {
+		entry = formatInput(entry)
+		cl.addHistory(entry)
+		cl.added = append(cl.added, entry)
 	}
-	cl.State.AppendHistory(strings.Replace(cl.buffer, "\n", ";", -1))
 	cl.buffer = ""
 }
 
-func (cl *contLiner) Reindent() {
-	oldDepth := cl.depth
-	cl.depth = cl.countDepth()
-
-	if cl.depth < oldDepth {
-		lines := strings.Split(cl.buffer, "\n")
-		if len(lines) > 1 {
-			lastLine := lines[len(lines)-1]
-			fmt.Print("\x1b[1A") // Cursor up one
-			fmt.Printf("\r%s%s", cl.promptString(""), lastLine)
-			fmt.Print("\x1b[0K") // Erase to right
-			fmt.Print("\n")
+// indentation scans src with go/scanner, and returns the depth of brackets
+// left open at its end, the depth its last line belongs at, and whether
+// the last line starts, and src ends, inside a raw string
+func indentation(src string) (depth, last int, lastInRaw, inRaw bool) {
+	var s goscanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(src))
+	s.Init(file, []byte(src), nil, goscanner.ScanComments)
+
+	lastLine := strings.Count(src, "\n") + 1
+	last = -1
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
+		}
+		line := file.Line(pos)
+		if tok == token.STRING && strings.HasPrefix(lit, "`") {
+			end := line + strings.Count(lit, "\n")
+			if len(lit) == 1 || !strings.HasSuffix(lit, "`") {
+				inRaw = true
+			}
+			if line < lastLine && end >= lastLine {
+				lastInRaw = true
+			}
+		}
+		if line == lastLine && last == -1 {
+			last = depth
+			switch tok {
+			case token.RBRACE, token.RPAREN, token.RBRACK, token.CASE, token.DEFAULT:
+				last--
+			}
 		}
-	}
-}
-
-func (cl *contLiner) countDepth() int {
-	depth := 0
 
-	s := new(scanner.Scanner)
-	s.Init(bytes.NewBufferString(cl.buffer))
-	tok := s.Scan()
-	for tok != scanner.EOF {
 		switch tok {
-		case '{', '(':
+		case token.LBRACE, token.LPAREN, token.LBRACK:
 			depth++
-		case '}', ')':
+		case token.RBRACE, token.RPAREN, token.RBRACK:
 			depth--
 		}
-		tok = s.Scan()
 	}
 
+	if last == -1 {
+		last = depth
+	}
 	if depth < 0 {
 		depth = 0
 	}
-	return depth
+	if last < 0 {
+		last = 0
+	}
+	return
 }
@@ -0,0 +1,62 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockFile takes an advisory lock, shared or exclusive, on file, and returns
+// the function that releases it. The lock is held on a hidden file next to
+// file, so that file itself may be replaced.
+func lockFile(file string, exclusive bool) (unlock func(), err error) {
+	f, err := os.OpenFile(lockName(file), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	how := unix.LOCK_SH
+	if exclusive {
+		how = unix.LOCK_EX
+	}
+	for {
+		if err = unix.Flock(int(f.Fd()), how); err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// tryLockFile takes an exclusive lock on file as lockFile does, if no one
+// else holds a lock on it, and tells whether it did
+func tryLockFile(file string) (unlock func(), ok bool, err error) {
+	f, err := os.OpenFile(lockName(file), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	for {
+		if err = unix.Flock(int(f.Fd()), unix.LOCK_EX|unix.LOCK_NB); err != unix.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		if err == unix.EWOULDBLOCK {
+			err = nil
+		}
+		return nil, false, err
+	}
+	return func() {
+		unix.Flock(int(f.Fd()), unix.LOCK_UN)
+		f.Close()
+	}, true, nil
+}
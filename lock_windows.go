@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockFile takes a lock, shared or exclusive, on file, and returns the
+// function that releases it. The lock is held on a hidden file next to
+// file, so that file itself may be replaced.
+func lockFile(file string, exclusive bool) (unlock func(), err error) {
+	f, err := os.OpenFile(lockName(file), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	flags := uint32(0)
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+	ol := new(windows.Overlapped)
+	if err = windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+		f.Close()
+	}, nil
+}
+
+// tryLockFile takes an exclusive lock on file as lockFile does, if no one
+// else holds a lock on it, and tells whether it did
+func tryLockFile(file string) (unlock func(), ok bool, err error) {
+	f, err := os.OpenFile(lockName(file), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, false, err
+	}
+	ol := new(windows.Overlapped)
+	flags := uint32(windows.LOCKFILE_EXCLUSIVE_LOCK | windows.LOCKFILE_FAIL_IMMEDIATELY)
+	if err = windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, 1, 0, ol); err != nil {
+		f.Close()
+		if err == windows.ERROR_LOCK_VIOLATION {
+			err = nil
+		}
+		return nil, false, err
+	}
+	return func() {
+		windows.UnlockFileEx(windows.Handle(f.Fd()), 0, 1, 0, ol)
+		f.Close()
+	}, true, nil
+}
@@ -4,9 +4,12 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"go/ast"
+	"go/format"
 	"go/parser"
 	"go/printer"
 	"go/scanner"
@@ -22,12 +25,16 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"unicode"
 )
 
 var (
 	home = filepath.Join(os.Getenv("HOME"), ".gop")
+	// buildDir is where this gop writes and builds its program, apart from
+	// any other gop running at the same time
+	buildDir string
 )
 
 // Workspace is the main struct for gop
@@ -38,6 +45,43 @@ type Workspace struct {
 	codes         []interface{}
 	files         *token.FileSet
 	args          string
+	// env holds the changes made to the environment of the program, dir
+	// the directory it runs in
+	env        []string
+	dir        string
+	build      buildSettings
+	transcript []*step
+	// undo holds the states of the workspace undo and redo go through,
+	// undoPos the current one
+	undo    []*snapshot
+	undoPos int
+	// checkpoints are saved by name, fork names the one that follows
+	// the workspace
+	checkpoints map[string]*checkpoint
+	fork        string
+	// session names the journal the workspace is written to, journaled
+	// is the state last written, and unlock lets other gops use it
+	session   string
+	journaled *snapshot
+	unlock    func()
+	// binary is the workspace the program in buildDir was built from
+	binary *snapshot
+}
+
+// step is one accepted input together with the output of its run. stdout
+// and stderr are all the program wrote, output only what it wrote after
+// what the run before this input did.
+type step struct {
+	input  string
+	codes  []interface{}
+	added  []interface{}
+	stdout string
+	stderr string
+	output string
+	// ran is false for steps that were only compiled, and custom true for
+	// runs with arguments or input of their own
+	ran    bool
+	custom bool
 }
 
 func (w *Workspace) source(printDpc, printLinenums, printNotimport bool) string {
@@ -122,7 +166,7 @@ func (w *Workspace) source(printDpc, printLinenums, printNotimport bool) string
 }
 
 func compile(w *Workspace) (err error) {
-	file := filepath.Join(home, "gop.go")
+	file := filepath.Join(buildDir, "gop.go")
 	ioutil.WriteFile(file, []byte(w.source(false, false, false)), 0644)
 
 	out := ""
@@ -131,33 +175,87 @@ func compile(w *Workspace) (err error) {
 	} else {
 		out = "gop"
 	}
-	out = filepath.Join(home, out)
+	out = filepath.Join(buildDir, out)
 
 	args := []string{}
 	args = append(args, "build")
+	args = append(args, conf.BuildFlags...)
+	args = append(args, w.build.flags()...)
 	args = append(args, "-o", out, file)
-	stdoutStderr, err := exec.Command("go", args...).CombinedOutput()
+	cmd := exec.Command(conf.Go, args...)
+	if len(w.build.env) > 0 {
+		cmd.Env = childEnv(w.build.env)
+	}
+	if pkg != nil {
+		cmd.Args, err = pkgBuildArgs(w, out)
+		if err != nil {
+			return
+		}
+		cmd.Args = append([]string{conf.Go}, cmd.Args...)
+		cmd.Dir = pkg.Dir
+	}
+	stdoutStderr, err := cmd.CombinedOutput()
 	if err != nil {
 		if len(stdoutStderr) > 0 {
 			err = fmt.Errorf("%s", stdoutStderr)
 		}
 		return
 	}
+	w.binary = w.snapshot()
 
 	return
 }
 
-func run(w *Workspace) (hasOutput bool, err error) {
-	file := filepath.Join(home, "gop")
-	matchs := regexp.MustCompile(`-?\w+|".*?[^\\"]"`).FindAllString(w.args, -1)
-	for n, match := range matchs {
-		matchs[n] = strings.Replace(strings.Trim(match, "\""), `\"`, `"`, -1)
-	}
+func run(w *Workspace) (stdout, stderr string, err error) {
+	return runWith(w, runOptions{args: w.runArgs()})
+}
+
+// runWith runs the program last built as opts says
+func runWith(w *Workspace, opts runOptions) (stdout, stderr string, err error) {
+	file := filepath.Join(buildDir, "gop")
 
 	outBuf := new(bytes.Buffer)
 	errBuf := new(bytes.Buffer)
 
-	cmd := exec.Command(file, matchs...)
+	cmd := exec.Command(file, opts.args...)
+	if conf.RunTimeout.Duration > 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), conf.RunTimeout.Duration)
+		defer cancel()
+		cmd = exec.CommandContext(ctx, file, opts.args...)
+		defer func() {
+			if ctx.Err() == context.DeadlineExceeded {
+				err = fmt.Errorf("killed after run timeout %s", conf.RunTimeout)
+			}
+		}()
+	}
+	if len(w.env) > 0 || len(opts.env) > 0 {
+		cmd.Env = childEnv(w.env, opts.env)
+	}
+	cmd.Dir = w.dir
+
+	stdoutW, stderrW := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if w.build.race {
+		// race reports tell the entries of the lines they name
+		ew := &entryWriter{w: os.Stderr, entries: w.lineEntries()}
+		defer ew.Flush()
+		stderrW = ew
+		if conf.RunPty {
+			ew.w = os.Stdout
+			stdoutW = ew
+		}
+	}
+	if conf.RunPty {
+		stdout, err = runPty(cmd, opts.stdin, stdoutW)
+		return
+	}
+	cmd.Stdin = opts.stdin
+	// the program reads what is typed while it runs, where the editor can
+	// stop reading the terminal meanwhile
+	if cmd.Stdin == nil && rl != nil && rl.ed.plain == nil && rl.paste != nil {
+		rl.paste.pause()
+		defer rl.paste.resume()
+		cmd.Stdin = rl.paste.terminal()
+	}
 	cmdout, err := cmd.StdoutPipe()
 	if err != nil {
 		return
@@ -167,30 +265,25 @@ func run(w *Workspace) (hasOutput bool, err error) {
 		return
 	}
 
-	stdout := io.MultiWriter(os.Stdout, outBuf)
-	stderr := io.MultiWriter(os.Stderr, errBuf)
-
 	err = cmd.Start()
 	if err != nil {
 		return
 	}
 
+	done := make(chan bool, 2)
 	go func() {
-		io.Copy(stdout, cmdout)
+		io.Copy(io.MultiWriter(stdoutW, outBuf), cmdout)
+		done <- true
 	}()
 	go func() {
-		io.Copy(stderr, cmderr)
+		io.Copy(io.MultiWriter(stderrW, errBuf), cmderr)
+		done <- true
 	}()
+	<-done
+	<-done
 
 	err = cmd.Wait()
-	if err != nil {
-		return
-	}
-
-	if outBuf.Len() > 0 || errBuf.Len() > 0 {
-		hasOutput = true
-	}
-
+	stdout, stderr = outBuf.String(), errBuf.String()
 	return
 }
 
@@ -218,153 +311,134 @@ func parseStmtList(fset *token.FileSet, filename string, src string) ([]ast.Stmt
 	return f.Decls[0].(*ast.FuncDecl).Body.List, nil
 }
 
-func sourceDefaultDPC(w *Workspace) {
-	for _, value := range []string{
-		"fmt",
-		"strconv",
-		"strings",
-		"time",
-		"encoding/json",
-		"bytes",
+// parseInput parses src as declarations, or else as statements. When
+// neither parses, src is not complete if the syntax error comes at its end,
+// such as an open brace or a trailing operator, and otherwise the error is
+// returned with its position in src.
+func parseInput(fset *token.FileSet, src string) (tree interface{}, notComplete bool, err error) {
+	decls, declErr := parseDeclList(fset, "gop", src)
+	if declErr == nil {
+		return decls, false, nil
+	}
+	stmts, stmtErr := parseStmtList(fset, "gop", src)
+	if stmtErr == nil {
+		return stmts, false, nil
+	}
+
+	prefix := ""
+	if strings.Index(src, "package ") == -1 {
+		prefix = "package p;"
+	}
+
+	var first *scanner.Error
+	for _, v := range []struct {
+		err    error
+		prefix string
+	}{
+		{declErr, prefix},
+		{stmtErr, prefix + "func _(){"},
 	} {
-		if func() bool {
-			for _, pkg := range w.pkgs {
-				v := pkg.(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
-				if v.Path.Value == "\""+value+"\"" &&
-					v.Name == nil {
-					return true
-				}
-			}
-			return false
-		}() {
+		list, ok := v.err.(scanner.ErrorList)
+		if !ok || len(list) == 0 {
+			return nil, false, v.err
+		}
+		e := list[0]
+		end := len(v.prefix) + len(src)
+		if e.Pos.Offset >= end && strings.HasPrefix(e.Msg, "expected declaration") {
+			// src closed the func that its statements are wrapped in
 			continue
 		}
-		tree, _ := parseDeclList(w.files, "gop", "import \""+value+"\"")
-		w.pkgsNotimport = append(w.pkgsNotimport, tree[0])
+		if e.Pos.Offset >= end ||
+			strings.HasSuffix(e.Msg, "raw string literal not terminated") ||
+			strings.HasSuffix(e.Msg, "comment not terminated") {
+			return nil, true, nil
+		}
+		if e.Pos.Line == 1 {
+			e.Pos.Column -= len(v.prefix)
+		}
+		if first == nil || e.Pos.Offset-len(v.prefix) > first.Pos.Offset {
+			first = &scanner.Error{Pos: e.Pos, Msg: e.Msg}
+			first.Pos.Offset -= len(v.prefix)
+		}
 	}
+	return nil, false, fmt.Errorf("%d:%d: %s", first.Pos.Line, first.Pos.Column, first.Msg)
 }
 
-func execAlias(w *Workspace, line string) string {
-	if line == "help" {
-		return "?"
+// formatInput returns src formatted by gofmt, or src itself if it does not
+// parse as declarations or statements
+func formatInput(src string) string {
+	if strings.Index(src, "package ") != -1 {
+		return src
 	}
 
-	sps := []string{}
-	for _, sp := range strings.Split(line, "\n") {
-		if p := "echo "; strings.HasPrefix(sp, p) {
-			sps = append(sps, "println("+sp[len(p):]+")")
-		} else {
-			sps = append(sps, sp)
-		}
+	if bs, err := format.Source([]byte("package p\n\n" + src)); err == nil {
+		return strings.TrimSpace(strings.TrimPrefix(string(bs), "package p\n"))
 	}
-	return strings.Join(sps, "\n")
-}
 
-func execSpecial(w *Workspace, line string) bool {
-	if strings.HasPrefix(line, ">") {
-		file := strings.TrimSpace(line[1:])
-		if file != "" {
-			file = filepath.Join(home, file)
-			if !strings.HasSuffix(file, ".tmpl") {
-				file += ".tmpl"
-			}
-			ioutil.WriteFile(file, []byte(w.source(false, false, false)), 0644)
-		}
-		return true
+	const wrapBegin, wrapEnd = "package p\n\nfunc _() {\n", "\n}\n"
+	bs, err := format.Source([]byte(wrapBegin + src + wrapEnd))
+	if err != nil {
+		return src
 	}
-	if strings.HasPrefix(line, "<") && !strings.HasPrefix(line, "<-") {
-		file := strings.TrimSpace(line[1:])
-		if file == "" {
-			fmt.Println("No file specified for include.")
-			return true
-		}
-		if !strings.HasSuffix(file, ".tmpl") {
-			file += ".tmpl"
+	body := string(bs)
+	if !strings.HasPrefix(body, wrapBegin) || !strings.HasSuffix(body, wrapEnd) {
+		return src
+	}
+	body = body[len(wrapBegin) : len(body)-len(wrapEnd)]
+
+	// take out the indentation of the wrapping func, except on lines that
+	// go on a raw string
+	var s scanner.Scanner
+	fset := token.NewFileSet()
+	file := fset.AddFile("", fset.Base(), len(body))
+	s.Init(file, []byte(body), nil, 0)
+	inRaw := map[int]bool{}
+	for {
+		pos, tok, lit := s.Scan()
+		if tok == token.EOF {
+			break
 		}
-		bs, err := ioutil.ReadFile(file)
-		if err != nil {
-			if os.IsNotExist(err) {
-				bs, err = ioutil.ReadFile(filepath.Join(home, file))
-			}
-			if err != nil {
-				fmt.Println("ReadFile error:", err)
-				return true
+		if tok == token.STRING && strings.HasPrefix(lit, "`") {
+			line := file.Line(pos)
+			for n := 1; n <= strings.Count(lit, "\n"); n++ {
+				inRaw[line+n] = true
 			}
 		}
-
-		sepBegin, sepEnd := "func main() {", "}"
-		if pos := strings.Index(string(bs), sepBegin); pos != -1 {
-			bs = append(bs[:pos], bs[pos+len(sepBegin):]...)
-			if pos := strings.LastIndex(string(bs), sepEnd); pos != -1 {
-				bs = append(bs[:pos], bs[pos+len(sepEnd):]...)
-			}
+	}
+	lines := strings.Split(body, "\n")
+	for n, line := range lines {
+		if !inRaw[n+1] {
+			lines[n] = strings.TrimPrefix(line, "\t")
 		}
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
 
-		w.pkgs = nil
-		w.pkgsNotimport = nil
-		w.codes = nil
-		w.defs = nil
-		tmpline := ""
-		for _, line := range strings.Split(string(bs), "\n") {
-			tmpline += line + "\n"
-			notComplete, err := parseGo4import(w, tmpline)
-			if err != nil {
-				fmt.Println("ParseGo error:", err)
-				break
-			}
-			if notComplete {
-				continue
+func sourceDefaultDPC(w *Workspace) {
+	values := append([]string(nil), conf.Imports...)
+	if pkg != nil {
+		for _, value := range pkg.Imports {
+			if value != "C" {
+				values = append(values, value)
 			}
-			tmpline = ""
 		}
-		sourceDefaultDPC(w)
-		return true
 	}
-	if line == "reset" {
-		w.pkgs = nil
-		w.pkgsNotimport = nil
-		w.defs = nil
-		w.codes = nil
-		sourceDefaultDPC(w)
-		return true
-	}
-	if line == "list" {
-		entries, err := ioutil.ReadDir(home)
-		if err != nil {
-			fmt.Printf("ReadDir %s: %s\n", home, err)
-			return true
-		}
-
-		tmpls := []string{}
-		for _, fi := range entries {
-			if fi.IsDir() {
-				continue
-			}
-
-			name := fi.Name()
-			if strings.HasPrefix(name, ".") ||
-				!strings.HasSuffix(name, ".tmpl") {
-				continue
+	for _, value := range values {
+		if func() bool {
+			for _, p := range append(append([]interface{}(nil), w.pkgs...), w.pkgsNotimport...) {
+				v := p.(*ast.GenDecl).Specs[0].(*ast.ImportSpec)
+				if v.Path.Value == "\""+value+"\"" &&
+					v.Name == nil {
+					return true
+				}
 			}
-
-			tmpls = append(tmpls, name)
-		}
-		for pos, tmpl := range tmpls {
-			fmt.Printf("%d\t%s\n", pos, tmpl)
+			return false
+		}() {
+			continue
 		}
-		return true
-	}
-	if line == "arg" {
-		fmt.Printf("%s\n", w.args)
-		return true
-	}
-	if p := "arg "; strings.HasPrefix(line, p) &&
-		!strings.HasPrefix(line, p+"=") &&
-		!strings.HasPrefix(line, p+":=") {
-		w.args = strings.TrimSpace(line[len(p):])
-		return true
+		tree, _ := parseDeclList(w.files, "gop", "import \""+value+"\"")
+		w.pkgsNotimport = append(w.pkgsNotimport, tree[0])
 	}
-	return false
 }
 
 func removeByIndex(w *Workspace, cmdArgs string) {
@@ -389,6 +463,12 @@ func removeByIndex(w *Workspace, cmdArgs string) {
 		return
 	}
 	itemsToRemove := getIndices(itemListLen, cmdArgs[1:])
+	removed, entries := []interface{}{}, w.entries(itemType)
+	for pos, v := range itemsToRemove {
+		if v {
+			removed = append(removed, entries[pos])
+		}
+	}
 
 	switch itemType {
 	case 'd':
@@ -407,6 +487,7 @@ func removeByIndex(w *Workspace, cmdArgs string) {
 	case 'c':
 		removeSlice(&w.codes, itemsToRemove)
 	}
+	w.pruneTranscript(removed)
 }
 
 func getIndices(itemListLen int, cmdArgs string) []bool {
@@ -475,32 +556,71 @@ func removeSlice(ps interface{}, removes []bool) {
 	rps.Set(rpsNew)
 }
 
-func parseGo(w *Workspace, line string) (notComplete bool, err error) {
-	pos := -1
-	if unicode.IsDigit(rune(line[0])) {
-		idx := strings.IndexFunc(line[1:], func(r rune) bool { return !unicode.IsDigit(r) })
-		if idx == -1 {
-			return
+// cutPosition splits the position an input is to be added at off line, as
+// in 3fmt.Println(), if line is not valid go as it is. It returns -1 for
+// line without one, and false for a position without code.
+func cutPosition(line string) (pos int, rest string, ok bool) {
+	if !unicode.IsDigit(rune(line[0])) || parsesAsGo(line) {
+		return -1, line, true
+	}
+	idx := strings.IndexFunc(line[1:], func(r rune) bool { return !unicode.IsDigit(r) })
+	if idx == -1 {
+		return
+	}
+	idx++
+	pos, err := strconv.Atoi(line[:idx])
+	if err != nil {
+		return
+	}
+	return pos, strings.TrimSpace(line[idx:]), true
+}
+
+// useDefined returns stmts with _ = name after each statement that defines
+// name, so that the program compiles while nothing uses name yet. defines
+// tells whether any of stmts assigns or declares.
+func useDefined(files *token.FileSet, stmts []ast.Stmt) (items []interface{}, defines bool) {
+	for _, stmt := range stmts {
+		items = append(items, stmt)
+		names := []*ast.Ident{}
+		switch v := stmt.(type) {
+		case *ast.AssignStmt:
+			if v.Tok == token.DEFINE {
+				for _, expr := range v.Lhs {
+					if ident, ok := expr.(*ast.Ident); ok {
+						names = append(names, ident)
+					}
+				}
+			}
+			defines = true
+		case *ast.DeclStmt:
+			if decl, ok := v.Decl.(*ast.GenDecl); ok && decl.Tok == token.VAR {
+				for _, spec := range decl.Specs {
+					names = append(names, spec.(*ast.ValueSpec).Names...)
+				}
+			}
+			defines = true
 		}
-		idx++
-		pos, err = strconv.Atoi(line[:idx])
-		if err != nil {
-			return
+		for _, name := range names {
+			if name.Name == "_" {
+				continue
+			}
+			tree, _ := parseStmtList(files, "gop", "_ = "+name.Name)
+			items = append(items, tree[0])
 		}
-		line = strings.TrimSpace(line[idx:])
+	}
+	return
+}
+
+func parseGo(w *Workspace, line string) (notComplete bool, err error) {
+	pos, line, ok := cutPosition(line)
+	if !ok {
+		return
 	}
 
 	var tree interface{}
-	tree, err = parseDeclList(w.files, "gop", line[0:])
-	if err != nil {
-		tree, err = parseStmtList(w.files, "gop", line[0:])
-		if err != nil {
-			if _, ok := err.(scanner.ErrorList); ok {
-				err = nil
-				notComplete = true
-			}
-			return
-		}
+	tree, notComplete, err = parseInput(w.files, line)
+	if tree == nil {
+		return
 	}
 
 	bkupPkgs := append([]interface{}(nil), w.pkgs...)
@@ -516,27 +636,9 @@ func parseGo(w *Workspace, line string) (notComplete bool, err error) {
 		if pos > len(w.codes) || pos < 0 {
 			pos = len(w.codes)
 		}
-		for i := len(v) - 1; i >= 0; i-- {
-			if vI, ok := v[i].(*ast.AssignStmt); ok {
-				if vI.Tok == token.DEFINE {
-					for _, nameI := range vI.Lhs {
-						strI := new(bytes.Buffer)
-						printer.Fprint(strI, w.files, nameI)
-						if strI.String() == "_" {
-							continue
-						}
-						tree, _ := parseStmtList(w.files, "gop", "_ = "+strI.String())
-						w.codes = append(w.codes, nil)
-						copy(w.codes[pos+1:], w.codes[pos:])
-						w.codes[pos] = tree[0]
-					}
-				}
-				isCodeDefine = true
-			}
-			w.codes = append(w.codes, nil)
-			copy(w.codes[pos+1:], w.codes[pos:])
-			w.codes[pos] = v[i]
-		}
+		var items []interface{}
+		items, isCodeDefine = useDefined(w.files, v)
+		w.codes = append(append(append([]interface{}(nil), w.codes[:pos]...), items...), w.codes[pos:]...)
 	case []ast.Decl:
 		if pos > len(w.defs) || pos < 0 {
 			pos = len(w.defs)
@@ -594,17 +696,59 @@ func parseGo(w *Workspace, line string) (notComplete bool, err error) {
 		return
 	}
 
-	var (
-		hasOutput bool
-		matches   [][]string
-	)
+	var stdout, stderr string
+
+	err = compileImports(w)
+	if err != nil {
+		goto restore
+	}
+	// declarations and imports only need to compile, the program runs
+	// when its code changes, if it is built to run here
+	if _, ok := tree.([]ast.Decl); ok || w.build.cross() {
+		w.addStep(&step{
+			input: formatInput(line),
+			codes: append([]interface{}(nil), w.codes...),
+			added: append(append(newEntries(bkupPkgs, w.pkgs), newEntries(bkupDefs, w.defs)...), newEntries(bkupCodes, w.codes)...),
+		}, bkupCodes)
+		return
+	}
+
+	stdout, stderr, err = run(w)
+	if err != nil {
+		goto restore
+	}
+	w.addStep(&step{
+		input:  formatInput(line),
+		codes:  append([]interface{}(nil), w.codes...),
+		added:  newEntries(bkupCodes, w.codes),
+		stdout: stdout,
+		stderr: stderr,
+		ran:    true,
+	}, bkupCodes)
+	if !isCodeDefine && (stdout != "" || stderr != "") {
+		goto restore
+	}
+	return
+
+restore:
+	w.pkgs = bkupPkgs
+	w.pkgsNotimport = bkupPkgsNotimport
+	w.codes = bkupCodes
+	w.defs = bkupDefs
+	w.files = bkupFiles
+	return
+}
 
+// compileImports compiles w. If that fails, it moves the imports the
+// compiler finds unused out of the program, and the ones it misses back in,
+// and tries again.
+func compileImports(w *Workspace) (err error) {
 	err = compile(w)
 	if err == nil {
-		goto run
+		return
 	}
 
-	matches = regexp.MustCompile(`imported and not used: (".+?")( as (.+))?`).FindAllStringSubmatch(err.Error(), -1)
+	matches := regexp.MustCompile(`imported and not used: (".+?")( as (.+))?`).FindAllStringSubmatch(err.Error(), -1)
 	if len(matches) == 0 {
 		matches = regexp.MustCompile(`(".+?") imported( as (.+))? and not used`).FindAllStringSubmatch(err.Error(), -1)
 	}
@@ -637,41 +781,14 @@ func parseGo(w *Workspace, line string) (notComplete bool, err error) {
 		}
 	}
 
-	err = compile(w)
-	if err == nil {
-		goto run
-	}
-
-	goto restore
-
-run:
-	hasOutput, err = run(w)
-	if err != nil || (!isCodeDefine && hasOutput) {
-		goto restore
-	}
-	return
-
-restore:
-	w.pkgs = bkupPkgs
-	w.pkgsNotimport = bkupPkgsNotimport
-	w.codes = bkupCodes
-	w.defs = bkupDefs
-	w.files = bkupFiles
-	return
+	return compile(w)
 }
 
 func parseGo4import(w *Workspace, line string) (notComplete bool, err error) {
 	var tree interface{}
-	tree, err = parseDeclList(w.files, "gop", line[0:])
-	if err != nil {
-		tree, err = parseStmtList(w.files, "gop", line[0:])
-		if err != nil {
-			if _, ok := err.(scanner.ErrorList); ok {
-				err = nil
-				notComplete = true
-			}
-			return
-		}
+	tree, notComplete, err = parseInput(w.files, line)
+	if tree == nil {
+		return
 	}
 
 	switch v := tree.(type) {
@@ -708,6 +825,16 @@ func parseGo4import(w *Workspace, line string) (notComplete bool, err error) {
 }
 
 func dispatch(w *Workspace, line string) (notComplete bool, err error) {
+	w.record()
+	defer func() {
+		w.record()
+		if err == nil {
+			if err := w.journal(); err != nil {
+				fmt.Println("Session error:", err)
+			}
+		}
+	}()
+
 	line = strings.TrimSpace(line)
 
 	line = execAlias(w, line)
@@ -715,54 +842,128 @@ func dispatch(w *Workspace, line string) (notComplete bool, err error) {
 		return
 	}
 
-	if execSpecial(w, line) {
+	if cmd, cmdArgs, ok := parseCommand(w, line); ok {
+		if cmd == nil {
+			err = errors.New("unknown command: " + line)
+			return
+		}
+		err = cmd.exec(w, cmdArgs)
 		return
 	}
 
-	switch line[0] {
-	case '?':
-		fmt.Println("Commands:")
-		fmt.Println("\t?|help\thelp menu")
-		fmt.Println("\t-[dpc][#],[#]-[#],...\tpop last/specific (declaration|package|code)")
-		fmt.Println("\t![!]\tinspect source [with linenum]")
-		fmt.Println("\t<tmpl\tsource tmpl")
-		fmt.Println("\t>tmpl\twrite tmpl")
-		fmt.Println("\t[#](...)\tadd def or code")
-		fmt.Println("\treset\treset")
-		fmt.Println("\tlist\ttmpl list")
-		fmt.Println("\targ\tset or get command-line argument")
-	case '-':
-		cmdArgs := strings.TrimSpace(line[1:])
-		removeByIndex(w, cmdArgs)
-	case '!':
-		cmdArgs := strings.TrimSpace(line[1:])
-		if cmdArgs == "!" {
-			fmt.Println(w.source(true, true, true))
-		} else {
-			fmt.Println(w.source(true, false, true))
-		}
-	default:
-		return parseGo(w, line)
+	return parseGo(w, line)
+}
+
+// incomplete tells whether text needs more lines to be run, without
+// running it
+func incomplete(w *Workspace, text string) bool {
+	line := execAlias(w, strings.TrimSpace(text))
+	if line == "" {
+		return false
+	}
+	if _, _, ok := parseCommand(w, line); ok {
+		return false
 	}
+	_, line, ok := cutPosition(line)
+	if !ok {
+		return false
+	}
+	_, notComplete, _ := parseInput(token.NewFileSet(), line)
+	return notComplete
+}
 
-	return
+// exits are run, last first, when gop ends, whether its input ends or a
+// signal ends it
+var (
+	exitMu sync.Mutex
+	exits  []func()
+)
+
+func atExit(f func()) {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	exits = append(exits, f)
+}
+
+func cleanup() {
+	exitMu.Lock()
+	defer exitMu.Unlock()
+	for i := len(exits) - 1; i >= 0; i-- {
+		exits[i]()
+	}
+	exits = nil
+}
+
+// lastResult returns the output of the last run
+func (w *Workspace) lastResult() string {
+	last := w.lastRun(true)
+	if last == nil {
+		return ""
+	}
+	return strings.TrimRight(last.stdout, "\n")
 }
 
 func main() {
+	pkgFlag := flag.String("pkg", "", "run the session inside the package in `dir`, with access to its unexported identifiers")
+	sessionFlag := flag.String("session", "default", "journal the workspace as session `name`, and offer to restore it at start")
+	flag.Parse()
+
+	if err := checkSessionName(*sessionFlag); err != nil {
+		fmt.Println("Session error:", err)
+		os.Exit(1)
+	}
+
+	loadConfig()
+
 	fmt.Println("Welcome to the Go Partner! [version: 1.7, created by simplejia]")
 	fmt.Println("Enter '?' for a list of commands.")
 
+	if *pkgFlag != "" {
+		if err := loadPkg(*pkgFlag); err != nil {
+			fmt.Println("Load package error:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Running inside package %s (%s)\n", pkg.Name, pkg.Dir)
+	}
+
+	dir, err := ioutil.TempDir("", "gop")
+	if err != nil {
+		fmt.Println("Mkdir error: ", err)
+		os.Exit(1)
+	}
+	buildDir = dir
+	defer cleanup()
+	atExit(func() {
+		os.RemoveAll(buildDir)
+	})
+
 	go func() {
-		signalChan := make(chan os.Signal)
-		signal.Notify(signalChan, syscall.SIGINT)
-		for {
-			<-signalChan
+		signalChan := make(chan os.Signal, 1)
+		signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+		for sig := range signalChan {
+			if sig != syscall.SIGINT {
+				cleanup()
+				os.Exit(1)
+			}
 		}
 	}()
 
 	w := &Workspace{
 		files: token.NewFileSet(),
 	}
+	// only the default session goes on under another name when in use
+	sessionSet := false
+	flag.Visit(func(f *flag.Flag) {
+		sessionSet = sessionSet || f.Name == "session"
+	})
+	if err := openSession(w, *sessionFlag, !sessionSet); err != nil {
+		fmt.Println("Session error:", err)
+		cleanup()
+		os.Exit(1)
+	}
+	atExit(func() {
+		w.unlock()
+	})
 	sourceDefaultDPC(w)
 
 	ifTmplExist, tmplFile := true, "gop.tmpl"
@@ -772,39 +973,52 @@ func main() {
 		}
 	}
 	if ifTmplExist {
-		dispatch(w, "<"+tmplFile)
+		if err := cmdLoad(w, tmplFile); err != nil {
+			fmt.Println("Error:", err)
+		}
 	}
 
-	rl := newContLiner()
-	defer rl.Close()
+	rl = newContLiner()
+	atExit(func() {
+		rl.Close()
+	})
+	rl.ed.incomplete = func(text string) bool {
+		return incomplete(w, text)
+	}
+	rl.ed.lastResult = w.lastResult
+	rl.ed.run = func(line string) {
+		if _, err := dispatch(w, line); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
 
 	if err := os.MkdirAll(home, 0755); err != nil {
 		fmt.Println("Mkdir error: ", err)
+		cleanup()
 		os.Exit(1)
 	}
 
-	historyFile := filepath.Join(home, "history")
-	if f, err := os.Open(historyFile); err != nil {
-		if !os.IsNotExist(err) {
-			fmt.Printf("OpenFile %s error: %v\n", historyFile, err)
-		}
-	} else {
-		defer f.Close()
-		rl.ReadHistory(f)
+	historyFile := historyFile()
+	if err := rl.loadHistory(historyFile); err != nil && !os.IsNotExist(err) {
+		fmt.Printf("OpenFile %s error: %v\n", historyFile, err)
 	}
 
-	defer func() {
-		if f, err := os.Create(historyFile); err != nil {
-			fmt.Printf("Open %s error: %v\n", historyFile, err)
-		} else {
-			rl.WriteHistory(f)
+	atExit(func() {
+		if err := rl.saveHistory(historyFile, conf.HistorySize); err != nil {
+			fmt.Printf("Write %s error: %v\n", historyFile, err)
 		}
-	}()
+	})
+
+	offerSession(w)
+	w.journaled = w.journalState()
 
 	for {
 		rl.SetWordCompleter(w.completeWord)
 
-		PS1 := "GOP$ "
+		PS1 := conf.Prompt
+		if settings := w.build.String(); settings != "" {
+			PS1 = "[" + settings + "] " + PS1
+		}
 		in, err := rl.Prompt(PS1)
 		if err != nil {
 			if err == io.EOF {
@@ -819,8 +1033,6 @@ func main() {
 			continue
 		}
 
-		rl.Reindent()
-
 		notComplete, err := dispatch(w, in)
 		if err != nil {
 			fmt.Println("Error:", err)
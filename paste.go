@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+const (
+	pasteBegin = "\x1b[200~"
+	pasteEnd   = "\x1b[201~"
+)
+
+// pasteReader owns the terminal's stdin and feeds the editor through a pipe. It
+// takes pasted text out of the stream, either marked by bracketed paste or
+// arriving as several lines in one read, and ends the current prompt with a
+// carriage return instead, so that the paste reaches dispatch as one input.
+type pasteReader struct {
+	in  *os.File
+	out *os.File
+	// a write to wake stops the pump waiting for input
+	wake, woken *os.File
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	pastes []string
+	// paused stops the pump reading stdin, and idle tells that it has
+	// stopped
+	paused, idle bool
+}
+
+// newPasteReader puts a pasteReader in front of os.Stdin, if it is a
+// terminal. It must be called before newEditor.
+func newPasteReader() *pasteReader {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return nil
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil
+	}
+
+	woken, wake, err := os.Pipe()
+	if err != nil {
+		return nil
+	}
+
+	pr := &pasteReader{in: os.Stdin, out: w, wake: wake, woken: woken}
+	pr.cond = sync.NewCond(&pr.mu)
+	os.Stdin = r
+	fmt.Print("\x1b[?2004h") // enable bracketed paste
+	go pr.pump()
+	return pr
+}
+
+func (pr *pasteReader) Close() {
+	fmt.Print("\x1b[?2004l")
+}
+
+func (pr *pasteReader) pump() {
+	buf := make([]byte, 4096)
+	pending, paste, inPaste := "", "", false
+	for {
+		pr.mu.Lock()
+		for pr.paused {
+			pr.idle = true
+			pr.cond.Broadcast()
+			pr.cond.Wait()
+		}
+		pr.idle = false
+		pr.mu.Unlock()
+
+		if !readable(int(pr.in.Fd()), int(pr.woken.Fd())) {
+			continue
+		}
+		n, err := pr.in.Read(buf)
+		if err != nil {
+			pr.out.Close()
+			pr.mu.Lock()
+			pr.idle = true
+			pr.cond.Broadcast()
+			pr.mu.Unlock()
+			return
+		}
+		chunk := pending + string(buf[:n])
+		pending = ""
+
+		// several lines in a single read are a paste from a terminal
+		// without bracketed paste
+		if !inPaste && !strings.Contains(chunk, "\x1b") &&
+			strings.ContainsAny(strings.TrimRight(chunk, "\r\n"), "\r\n") {
+			pr.push(chunk)
+			continue
+		}
+
+		for chunk != "" {
+			marker := pasteBegin
+			if inPaste {
+				marker = pasteEnd
+			}
+			p := strings.Index(chunk, marker)
+			if p == -1 {
+				// keep what may be the start of a marker for the next read
+				keep := 0
+				for k := len(marker) - 1; k > 0; k-- {
+					if strings.HasSuffix(chunk, marker[:k]) {
+						keep = k
+						break
+					}
+				}
+				text := chunk[:len(chunk)-keep]
+				pending = chunk[len(chunk)-keep:]
+				if inPaste {
+					paste += text
+				} else {
+					io.WriteString(pr.out, text)
+				}
+				break
+			}
+
+			if inPaste {
+				pr.push(paste + chunk[:p])
+				paste = ""
+			} else {
+				io.WriteString(pr.out, chunk[:p])
+			}
+			inPaste = !inPaste
+			chunk = chunk[p+len(marker):]
+		}
+	}
+}
+
+// pause stops the pump reading the terminal, so that a program run in the
+// foreground gets what is typed, until resume is called
+func (pr *pasteReader) pause() {
+	if pr == nil {
+		return
+	}
+	fmt.Print("\x1b[?2004l")
+	pr.mu.Lock()
+	pr.paused = true
+	pr.wake.Write([]byte{0})
+	for !pr.idle {
+		pr.cond.Wait()
+	}
+	pr.mu.Unlock()
+}
+
+func (pr *pasteReader) resume() {
+	if pr == nil {
+		return
+	}
+	pr.mu.Lock()
+	pr.paused = false
+	pr.cond.Broadcast()
+	pr.mu.Unlock()
+	fmt.Print("\x1b[?2004h")
+}
+
+// terminal returns the stdin of a program run in the foreground
+func (pr *pasteReader) terminal() *os.File {
+	if pr == nil {
+		return os.Stdin
+	}
+	return pr.in
+}
+
+// push queues a paste and ends the prompt that is waiting for input
+func (pr *pasteReader) push(text string) {
+	text = strings.Replace(text, "\r\n", "\n", -1)
+	text = strings.Replace(text, "\r", "\n", -1)
+	text = strings.TrimRight(text, "\n")
+	if text == "" {
+		return
+	}
+
+	pr.mu.Lock()
+	pr.pastes = append(pr.pastes, text)
+	pr.mu.Unlock()
+	io.WriteString(pr.out, "\r")
+}
+
+// take returns the pastes queued since the last call
+func (pr *pasteReader) take() string {
+	if pr == nil {
+		return ""
+	}
+	pr.mu.Lock()
+	defer pr.mu.Unlock()
+	text := strings.Join(pr.pastes, "\n")
+	pr.pastes = nil
+	return text
+}
+
+// cmdPaste reads lines until conf.PasteEnd or ^D, and runs them as one input
+func cmdPaste(w *Workspace, args string) error {
+	if rl == nil {
+		return errors.New("no terminal to paste into")
+	}
+
+	fmt.Printf("// paste mode, end with %s or ^D\n", conf.PasteEnd)
+	buf := new(bytes.Buffer)
+	for {
+		line, err := rl.promptLine("")
+		if err == io.EOF {
+			break
+		}
+		if err == errPromptAborted {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if strings.TrimSpace(line) == conf.PasteEnd {
+			break
+		}
+		buf.WriteString(line + "\n")
+	}
+
+	text := strings.TrimSpace(buf.String())
+	if text == "" {
+		return nil
+	}
+	rl.buffer = text
+
+	notComplete, err := parseGo(w, execAlias(w, text))
+	if err == nil && notComplete {
+		err = errors.New("incomplete input")
+	}
+	return err
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/build"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const pkgMainSrc = `package %s
+
+import (
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	gopMain()
+	os.Exit(0)
+}
+`
+
+// pkg is the package the session runs inside, set by -pkg. Its directory is
+// never written to: the session is laid over it with go's -overlay flag as
+// test files of the package, and the package's own tests are hidden.
+var pkg *build.Package
+
+func loadPkg(dir string) (err error) {
+	dir, err = filepath.Abs(dir)
+	if err != nil {
+		return
+	}
+	p, err := build.ImportDir(dir, 0)
+	if err != nil {
+		return
+	}
+	pkg = p
+	return
+}
+
+// pkgBuildArgs writes the session and overlay files into buildDir and returns the
+// go arguments that build them, run from pkg.Dir, into out
+func pkgBuildArgs(w *Workspace, out string) (args []string, err error) {
+	src := w.source(false, false, false)
+	src = strings.Replace(src, "package main\n", "package "+pkg.Name+"\n", 1)
+	src = strings.Replace(src, "\nfunc main() {\n", "\nfunc gopMain() {\n", 1)
+
+	sessionFile := filepath.Join(buildDir, "gop_session_test.go")
+	if err = ioutil.WriteFile(sessionFile, []byte(src), 0644); err != nil {
+		return
+	}
+	mainFile := filepath.Join(buildDir, "gop_main_test.go")
+	if err = ioutil.WriteFile(mainFile, []byte(fmt.Sprintf(pkgMainSrc, pkg.Name)), 0644); err != nil {
+		return
+	}
+
+	replace := map[string]string{
+		filepath.Join(pkg.Dir, "gop_session_test.go"): sessionFile,
+		filepath.Join(pkg.Dir, "gop_main_test.go"):    mainFile,
+	}
+	for _, files := range [][]string{pkg.TestGoFiles, pkg.XTestGoFiles} {
+		for _, file := range files {
+			replace[filepath.Join(pkg.Dir, file)] = ""
+		}
+	}
+	bs, err := json.Marshal(map[string]interface{}{"Replace": replace})
+	if err != nil {
+		return
+	}
+	overlay := filepath.Join(buildDir, "overlay.json")
+	if err = ioutil.WriteFile(overlay, bs, 0644); err != nil {
+		return
+	}
+
+	args = append([]string{"test", "-c"}, conf.BuildFlags...)
+	args = append(args, w.build.flags()...)
+	args = append(args, "-o", out, "-overlay", overlay, ".")
+	return
+}
@@ -0,0 +1,30 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// readable waits for input on fd. It returns false when wake, a pipe that
+// is written to stop the wait, has input first.
+func readable(fd, wake int) bool {
+	fds := []unix.PollFd{
+		{Fd: int32(fd), Events: unix.POLLIN},
+		{Fd: int32(wake), Events: unix.POLLIN},
+	}
+	_, err := unix.Poll(fds, -1)
+	if err == unix.EINTR {
+		return false
+	}
+	if err != nil {
+		// left for read to report
+		return true
+	}
+	if fds[1].Revents != 0 {
+		unix.Read(wake, make([]byte, 64))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,6 @@
+package main
+
+// readable is not used on windows, where stdin has no pasteReader
+func readable(fd, wake int) bool {
+	return true
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+	"golang.org/x/term"
+)
+
+// openPty opens a new pseudo-terminal, and returns its master and slave
+func openPty() (ptm, pts *os.File, err error) {
+	ptm, err = os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return
+	}
+	fd := int(ptm.Fd())
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err == nil {
+		var n uint32
+		if n, err = unix.IoctlGetUint32(fd, unix.TIOCGPTN); err == nil {
+			pts, err = os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+		}
+	}
+	if err != nil {
+		ptm.Close()
+	}
+	return
+}
+
+// resizePty gives the pseudo-terminal the size of gop's terminal
+func resizePty(ptm *os.File) {
+	if ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ); err == nil {
+		unix.IoctlSetWinsize(int(ptm.Fd()), unix.TIOCSWINSZ, ws)
+	}
+}
+
+// runPty runs cmd with its output on a pseudo-terminal of the size of
+// gop's terminal, copied to out. Unless stdin is given, what is typed goes to the program
+// with gop's terminal in raw mode, and the pseudo-terminal does the line
+// editing. It returns what the program wrote, with the \r\n of the
+// terminal turned back into \n.
+func runPty(cmd *exec.Cmd, stdin io.Reader, out io.Writer) (stdout string, err error) {
+	ptm, pts, err := openPty()
+	if err != nil {
+		return
+	}
+	defer ptm.Close()
+	resizePty(ptm)
+
+	// a write to wake stops the forwarding of what is typed
+	var tty, wake, woken *os.File
+	if stdin == nil && rl != nil && rl.ed.plain == nil {
+		if woken, wake, err = os.Pipe(); err != nil {
+			pts.Close()
+			return
+		}
+		defer woken.Close()
+		defer wake.Close()
+		tty = rl.paste.terminal()
+	}
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, pts, pts
+	if tty != nil {
+		cmd.Stdin = pts
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true, Setctty: true, Ctty: 1}
+	err = cmd.Start()
+	pts.Close()
+	if err != nil {
+		return
+	}
+
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer func() {
+		signal.Stop(winch)
+		close(winch)
+	}()
+	go func() {
+		for range winch {
+			resizePty(ptm)
+		}
+	}()
+
+	if tty != nil {
+		rl.paste.pause()
+		defer rl.paste.resume()
+		if state, err := term.MakeRaw(int(tty.Fd())); err == nil {
+			defer term.Restore(int(tty.Fd()), state)
+		}
+		stop, done := make(chan bool), make(chan bool)
+		go func() {
+			defer close(done)
+			buf := make([]byte, 1024)
+			for {
+				if !readable(int(tty.Fd()), int(woken.Fd())) {
+					// woken, or interrupted by a signal
+					select {
+					case <-stop:
+						return
+					default:
+						continue
+					}
+				}
+				n, err := tty.Read(buf)
+				if err != nil {
+					return
+				}
+				ptm.Write(buf[:n])
+			}
+		}()
+		defer func() {
+			close(stop)
+			wake.Write([]byte{0})
+			<-done
+		}()
+	}
+
+	// reading the master fails once the program and its children are gone
+	buf := new(bytes.Buffer)
+	io.Copy(io.MultiWriter(out, buf), ptm)
+	err = cmd.Wait()
+	stdout = strings.Replace(buf.String(), "\r\n", "\n", -1)
+	return
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os/exec"
+)
+
+func runPty(cmd *exec.Cmd, stdin io.Reader, out io.Writer) (string, error) {
+	return "", errors.New("run_pty is only supported on linux")
+}
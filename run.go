@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// runOptions change a single run of the program
+type runOptions struct {
+	args []string
+	// env is added to the environment of gop
+	env []string
+	// stdin is the terminal when it is nil and gop reads a terminal,
+	// except on windows, where the editor can not stop reading it
+	stdin io.Reader
+}
+
+// word is a word of a command line, plain if no part of it was quoted.
+// bare is the length of the text before its first quoted part.
+type word struct {
+	text  string
+	plain bool
+	bare  int
+}
+
+// splitWords splits s into words as a shell does: blanks separate words,
+// and single quotes, double quotes or a backslash keep them in a word
+func splitWords(s string) (words []word, err error) {
+	buf := new(bytes.Buffer)
+	in, plain, bare := false, true, 0
+	rs := []rune(s)
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		if plain && (r == '\\' || r == '\'' || r == '"') {
+			bare = buf.Len()
+		}
+		switch {
+		case r == '\\':
+			in, plain = true, false
+			if i+1 < len(rs) {
+				i++
+				buf.WriteRune(rs[i])
+			}
+		case r == '\'':
+			in, plain = true, false
+			for i++; ; i++ {
+				if i == len(rs) {
+					return nil, errors.New("unterminated ' quote")
+				}
+				if rs[i] == '\'' {
+					break
+				}
+				buf.WriteRune(rs[i])
+			}
+		case r == '"':
+			in, plain = true, false
+			for i++; ; i++ {
+				if i == len(rs) {
+					return nil, errors.New(`unterminated " quote`)
+				}
+				if rs[i] == '"' {
+					break
+				}
+				if rs[i] == '\\' && i+1 < len(rs) && (rs[i+1] == '"' || rs[i+1] == '\\') {
+					i++
+				}
+				buf.WriteRune(rs[i])
+			}
+		case unicode.IsSpace(r):
+			if in {
+				words = append(words, newWord(buf.String(), plain, bare))
+				buf.Reset()
+				in, plain = false, true
+			}
+		default:
+			in = true
+			buf.WriteRune(r)
+		}
+	}
+	if in {
+		words = append(words, newWord(buf.String(), plain, bare))
+	}
+	return
+}
+
+func newWord(text string, plain bool, bare int) word {
+	if plain {
+		bare = len(text)
+	}
+	return word{text, plain, bare}
+}
+
+func wordTexts(words []word) []string {
+	texts := []string{}
+	for _, w := range words {
+		texts = append(texts, w.text)
+	}
+	return texts
+}
+
+// runArgs returns the arguments set with arg, quoted as in a shell
+func (w *Workspace) runArgs() []string {
+	words, _ := splitWords(w.args)
+	return wordTexts(words)
+}
+
+var envWord = regexp.MustCompile(`^[A-Za-z_]\w*=`)
+
+// parseRun reads the command line of run: KEY=value words before the
+// arguments, whose KEY= is not quoted, set environment variables, < file names the file stdin is read
+// from and <<< text gives text as stdin. args stays nil when there are no
+// arguments.
+func parseRun(line string) (opts runOptions, stdinFile string, err error) {
+	words, err := splitWords(line)
+	if err != nil {
+		return
+	}
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		switch {
+		case w.plain && strings.HasPrefix(w.text, "<<<"):
+			text := w.text[3:]
+			if text == "" {
+				if i++; i == len(words) {
+					err = errors.New("no text for stdin")
+					return
+				}
+				text = words[i].text
+			}
+			opts.stdin = strings.NewReader(text + "\n")
+		case w.plain && strings.HasPrefix(w.text, "<"):
+			if stdinFile = w.text[1:]; stdinFile == "" {
+				if i++; i == len(words) {
+					err = errors.New("no file to read stdin from")
+					return
+				}
+				stdinFile = words[i].text
+			}
+		case opts.args == nil && envWord.MatchString(w.text[:w.bare]):
+			opts.env = append(opts.env, w.text)
+		default:
+			opts.args = append(opts.args, w.text)
+		}
+	}
+	return
+}
+
+// built tells whether the program last built is the one in w
+func (w *Workspace) built() bool {
+	if w.binary == nil {
+		return false
+	}
+	// how it runs does not change the program
+	s := w.snapshot()
+	s.args, s.env, s.dir, s.fork = w.binary.args, w.binary.env, w.binary.dir, w.binary.fork
+	return s.equal(w.binary)
+}
+
+// cmdRun runs the program, which is built again only if the workspace
+// changed since it was last built. Arguments given replace those set with
+// arg for this run.
+func cmdRun(w *Workspace, args string) error {
+	opts, stdinFile, err := parseRun(args)
+	if err != nil {
+		return err
+	}
+	if opts.args == nil {
+		opts.args = w.runArgs()
+	}
+	if stdinFile != "" {
+		f, err := os.Open(stdinFile)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		opts.stdin = f
+	}
+	if !w.built() {
+		if err = compileImports(w); err != nil {
+			return err
+		}
+	}
+	if w.build.cross() {
+		return errors.New("the program is built for another system: " + quoteWords(w.build.env))
+	}
+	stdout, stderr, err := runWith(w, opts)
+	if err != nil {
+		return err
+	}
+	w.transcript = append(w.transcript, &step{
+		input:  strings.TrimSpace("// :run " + args),
+		codes:  append([]interface{}(nil), w.codes...),
+		stdout: stdout,
+		stderr: stderr,
+		output: stdout + stderr,
+		ran:    true,
+		custom: true,
+	})
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestSplitWords(t *testing.T) {
+	for _, c := range []struct {
+		s     string
+		words []word
+		err   bool
+	}{
+		{"", nil, false},
+		{"  \t ", nil, false},
+		{"a", []word{{"a", true, 1}}, false},
+		{" a  b\tc ", []word{{"a", true, 1}, {"b", true, 1}, {"c", true, 1}}, false},
+		{`'a b' "c d"`, []word{{"a b", false, 0}, {"c d", false, 0}}, false},
+		{`''`, []word{{"", false, 0}}, false},
+		{`"" x`, []word{{"", false, 0}, {"x", true, 1}}, false},
+		{`a\ b`, []word{{"a b", false, 1}}, false},
+		{`--name='a b'c`, []word{{"--name=a bc", false, 7}}, false},
+		{`'it'\''s'`, []word{{"it's", false, 0}}, false},
+		{`"a \"b\" \\ \n"`, []word{{`a "b" \ \n`, false, 0}}, false},
+		{`'\'`, []word{{`\`, false, 0}}, false},
+		{`a\`, []word{{"a", false, 1}}, false},
+		{"héllo wörld", []word{{"héllo", true, 6}, {"wörld", true, 6}}, false},
+		{`'a`, nil, true},
+		{`"a`, nil, true},
+		{`"a\"`, nil, true},
+	} {
+		words, err := splitWords(c.s)
+		if (err != nil) != c.err || !reflect.DeepEqual(words, c.words) {
+			t.Errorf("splitWords(%q) = %v, %v; want %v, error %v", c.s, words, err, c.words, c.err)
+		}
+	}
+}
+
+func TestParseRun(t *testing.T) {
+	for _, c := range []struct {
+		line      string
+		args, env []string
+		stdin     string
+		stdinFile string
+		err       bool
+	}{
+		{"", nil, nil, "", "", false},
+		{"-v x", []string{"-v", "x"}, nil, "", "", false},
+		{"A=1 B='x y' -v", []string{"-v"}, []string{"A=1", "B=x y"}, "", "", false},
+		{"-v A=1", []string{"-v", "A=1"}, nil, "", "", false},
+		{"'A=1'", []string{"A=1"}, nil, "", "", false},
+		{"< in.txt", nil, nil, "", "in.txt", false},
+		{"x <in.txt", []string{"x"}, nil, "", "in.txt", false},
+		{"<<< '4 5'", nil, nil, "4 5\n", "", false},
+		{"<<<4 x", []string{"x"}, nil, "4\n", "", false},
+		{"'<' x", []string{"<", "x"}, nil, "", "", false},
+		{"<", nil, nil, "", "", true},
+		{"<<<", nil, nil, "", "", true},
+	} {
+		opts, stdinFile, err := parseRun(c.line)
+		if (err != nil) != c.err {
+			t.Errorf("parseRun(%q): error %v", c.line, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		stdin := ""
+		if opts.stdin != nil {
+			bs, _ := ioutil.ReadAll(opts.stdin)
+			stdin = string(bs)
+		}
+		if !reflect.DeepEqual(opts.args, c.args) || !reflect.DeepEqual(opts.env, c.env) ||
+			stdin != c.stdin || stdinFile != c.stdinFile {
+			t.Errorf("parseRun(%q) = %q, %q, stdin %q, file %q; want %q, %q, %q, %q",
+				c.line, opts.args, opts.env, stdin, stdinFile, c.args, c.env, c.stdin, c.stdinFile)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sessionDir holds a journal of each session, named after the session
+func sessionDir() string {
+	return filepath.Join(home, "session")
+}
+
+func sessionFile(name string) string {
+	return filepath.Join(sessionDir(), name)
+}
+
+// checkSessionName tells whether name can name a session file
+func checkSessionName(name string) error {
+	if name == "" || strings.ContainsAny(name, `/\`) ||
+		strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+		return errors.New("invalid session name " + name)
+	}
+	return nil
+}
+
+// lockSession keeps other gops from going on as session name, until the
+// function it returns is called
+func lockSession(name string) (unlock func(), err error) {
+	if err = os.MkdirAll(sessionDir(), 0755); err != nil {
+		return
+	}
+	unlock, ok, err := tryLockFile(sessionFile(name) + ".inuse")
+	if err == nil && !ok {
+		err = fmt.Errorf("session %s is used by another gop", name)
+	}
+	return
+}
+
+// openSession locks session name for w. When another gop uses it and
+// retry is set, w goes on as the first of name-2, name-3, ... that is free.
+func openSession(w *Workspace, name string, retry bool) error {
+	unlock, err := lockSession(name)
+	for n := 2; err != nil && retry && n < 100; n++ {
+		unlock, err = lockSession(fmt.Sprintf("%s-%d", name, n))
+		if err == nil {
+			fmt.Printf("Session %s is used by another gop, going on as %s-%d\n", name, name, n)
+			name = fmt.Sprintf("%s-%d", name, n)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	w.session, w.unlock = name, unlock
+	return nil
+}
+
+// readSession loads the journal of session name into a new workspace
+func readSession(name string) (*Workspace, os.FileInfo, error) {
+	file := sessionFile(name)
+	fi, err := os.Stat(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	bs, err := readFileLocked(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	c := &Workspace{files: token.NewFileSet(), session: name}
+	src, err := c.readMetadata(string(bs))
+	if err == nil {
+		err = sourceTmpl(c, src)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("session %s: %v", name, err)
+	}
+	return c, fi, nil
+}
+
+// journalState returns the part of the workspace a journal holds
+func (w *Workspace) journalState() *snapshot {
+	s := w.snapshot()
+	s.fork = ""
+	return s
+}
+
+// journal writes the workspace to its session file, if it changed since it
+// was last written
+func (w *Workspace) journal() error {
+	s := w.journalState()
+	if w.journaled != nil && s.equal(w.journaled) {
+		return nil
+	}
+	if err := os.MkdirAll(sessionDir(), 0755); err != nil {
+		return err
+	}
+	src := w.metadata() + w.source(false, false, true)
+	if err := writeFileLocked(sessionFile(w.session), []byte(src)); err != nil {
+		return err
+	}
+	w.journaled = s
+	return nil
+}
+
+// describe sums up the entries of w
+func (w *Workspace) describe() string {
+	return fmt.Sprintf("d%d p%d c%d", len(w.defs), len(w.pkgs)+len(w.pkgsNotimport), len(w.codes))
+}
+
+// offerSession asks whether to go on with the session left by the last run
+// of gop, and loads it if so
+func offerSession(w *Workspace) {
+	c, fi, err := readSession(w.session)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			fmt.Println("Error:", err)
+		}
+		return
+	}
+	if len(c.defs) == 0 && len(c.codes) == 0 && c.args == "" {
+		return
+	}
+	if rl == nil || rl.ed.plain != nil {
+		return
+	}
+	answer, err := rl.promptLine(fmt.Sprintf("Restore session %s (%s, %s)? [Y/n] ",
+		w.session, c.describe(), fi.ModTime().Format("2006-01-02 15:04:05")))
+	if err != nil {
+		return
+	}
+	if answer = strings.ToLower(strings.TrimSpace(answer)); answer == "" || answer == "y" || answer == "yes" {
+		w.useSession(c)
+		return
+	}
+	// the journal of the new session would overwrite it
+	name, err := keepSession(w.session, fi)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Session %s kept as %s\n", w.session, name)
+}
+
+// keepSession renames the journal of session name, whose file is fi, after
+// the time it was last written, and returns the new name
+func keepSession(name string, fi os.FileInfo) (string, error) {
+	kept := name + "-" + fi.ModTime().Format("20060102-150405")
+	for n := 2; ; n++ {
+		if _, err := os.Stat(sessionFile(kept)); err != nil {
+			break
+		}
+		kept = fmt.Sprintf("%s-%s-%d", name, fi.ModTime().Format("20060102-150405"), n)
+	}
+	return kept, os.Rename(sessionFile(name), sessionFile(kept))
+}
+
+// useSession puts the entries of c in place of w's
+func (w *Workspace) useSession(c *Workspace) {
+	w.pkgs, w.pkgsNotimport = c.pkgs, c.pkgsNotimport
+	w.defs, w.codes = c.defs, c.codes
+	w.files = c.files
+	w.args, w.env, w.dir, w.build = c.args, c.env, c.dir, c.build
+	w.transcript = nil
+}
+
+func listSessions(w *Workspace) error {
+	entries, err := ioutil.ReadDir(sessionDir())
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for _, fi := range entries {
+		name := fi.Name()
+		if fi.IsDir() || strings.HasPrefix(name, ".") || strings.HasSuffix(name, ".tmp") {
+			continue
+		}
+		mark, summary := " ", "?"
+		if name == w.session {
+			mark = "*"
+		}
+		if c, _, err := readSession(name); err == nil {
+			summary = c.describe()
+		}
+		fmt.Printf("%s %s\t%s\t%s\n", mark, name, summary, fi.ModTime().Format("2006-01-02 15:04:05"))
+	}
+	return nil
+}
+
+// cmdSession lists sessions, or switches to session name: its journal is
+// loaded if there is one, and otherwise the workspace goes on under the
+// new name
+func cmdSession(w *Workspace, args string) error {
+	if args == "" {
+		return listSessions(w)
+	}
+	if err := checkSessionName(args); err != nil {
+		return err
+	}
+	if args == w.session {
+		return nil
+	}
+	unlock, err := lockSession(args)
+	if err != nil {
+		return err
+	}
+	c, _, err := readSession(args)
+	if err != nil && !os.IsNotExist(err) {
+		unlock()
+		return err
+	}
+	if c != nil {
+		w.useSession(c)
+	}
+	if w.unlock != nil {
+		w.unlock()
+	}
+	w.session, w.unlock = args, unlock
+	w.journaled = nil
+	return nil
+}
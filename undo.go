@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"go/token"
+	"strings"
+)
+
+// undoLevels is the number of changes undo can go back
+const undoLevels = 100
+
+// snapshot is the state of the workspace that undo brings back
+type snapshot struct {
+	pkgs          []interface{}
+	pkgsNotimport []interface{}
+	defs          []interface{}
+	codes         []interface{}
+	files         *token.FileSet
+	args          string
+	env           []string
+	dir           string
+	build         buildSettings
+	fork          string
+}
+
+func (w *Workspace) snapshot() *snapshot {
+	return &snapshot{
+		pkgs:          append([]interface{}(nil), w.pkgs...),
+		pkgsNotimport: append([]interface{}(nil), w.pkgsNotimport...),
+		defs:          append([]interface{}(nil), w.defs...),
+		codes:         append([]interface{}(nil), w.codes...),
+		files:         w.files,
+		args:          w.args,
+		env:           append([]string(nil), w.env...),
+		dir:           w.dir,
+		build:         w.build,
+		fork:          w.fork,
+	}
+}
+
+func (w *Workspace) restore(s *snapshot) {
+	w.pkgs = append([]interface{}(nil), s.pkgs...)
+	w.pkgsNotimport = append([]interface{}(nil), s.pkgsNotimport...)
+	w.defs = append([]interface{}(nil), s.defs...)
+	w.codes = append([]interface{}(nil), s.codes...)
+	w.files = s.files
+	w.args = s.args
+	w.env = append([]string(nil), s.env...)
+	w.dir = s.dir
+	w.build = s.build
+	w.fork = s.fork
+}
+
+func sameEntries(a, b []interface{}) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (s *snapshot) equal(o *snapshot) bool {
+	return sameEntries(s.pkgs, o.pkgs) &&
+		sameEntries(s.pkgsNotimport, o.pkgsNotimport) &&
+		sameEntries(s.defs, o.defs) &&
+		sameEntries(s.codes, o.codes) &&
+		s.files == o.files && s.args == o.args && s.fork == o.fork &&
+		strings.Join(s.env, "\x00") == strings.Join(o.env, "\x00") && s.dir == o.dir &&
+		s.build.String() == o.build.String()
+}
+
+// record adds the workspace to the undo history, if it changed since it was
+// last recorded, and drops the changes that could be redone
+func (w *Workspace) record() {
+	s := w.snapshot()
+	w.follow(s)
+	if len(w.undo) > 0 {
+		if s.equal(w.undo[w.undoPos]) {
+			return
+		}
+		w.undo = w.undo[:w.undoPos+1]
+	}
+	w.undo = append(w.undo, s)
+	if len(w.undo) > undoLevels+1 {
+		w.undo = w.undo[1:]
+	}
+	w.undoPos = len(w.undo) - 1
+}
+
+func cmdUndo(w *Workspace, args string) error {
+	if w.undoPos == 0 {
+		return errors.New("nothing to undo")
+	}
+	w.undoPos--
+	w.restore(w.undo[w.undoPos])
+	return nil
+}
+
+func cmdRedo(w *Workspace, args string) error {
+	if w.undoPos >= len(w.undo)-1 {
+		return errors.New("nothing to redo")
+	}
+	w.undoPos++
+	w.restore(w.undo[w.undoPos])
+	return nil
+}
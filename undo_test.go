@@ -0,0 +1,67 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUndoRedo(t *testing.T) {
+	w := &Workspace{}
+	w.record()
+	w.codes = []interface{}{"a"}
+	w.record()
+	w.record()
+	w.codes = []interface{}{"a", "b"}
+	w.args = "-v"
+	w.record()
+	if len(w.undo) != 3 {
+		t.Fatalf("%d states recorded; want 3", len(w.undo))
+	}
+
+	for _, c := range []struct {
+		cmd   func(*Workspace, string) error
+		codes []interface{}
+		args  string
+		err   bool
+	}{
+		{cmdUndo, []interface{}{"a"}, "", false},
+		{cmdUndo, nil, "", false},
+		{cmdUndo, nil, "", true},
+		{cmdRedo, []interface{}{"a"}, "", false},
+		{cmdRedo, []interface{}{"a", "b"}, "-v", false},
+		{cmdRedo, []interface{}{"a", "b"}, "-v", true},
+		{cmdUndo, []interface{}{"a"}, "", false},
+	} {
+		err := c.cmd(w, "")
+		if (err != nil) != c.err || len(w.codes) != len(c.codes) ||
+			(len(c.codes) > 0 && !reflect.DeepEqual(w.codes, c.codes)) || w.args != c.args {
+			t.Fatalf("codes %v, args %q, error %v; want %v, %q, error %v", w.codes, w.args, err, c.codes, c.args, c.err)
+		}
+	}
+
+	// a change after undo drops what could be redone
+	w.codes = []interface{}{"c"}
+	w.record()
+	if err := cmdRedo(w, ""); err == nil {
+		t.Error("redo after a new change")
+	}
+	cmdUndo(w, "")
+	if !reflect.DeepEqual(w.codes, []interface{}{"a"}) {
+		t.Errorf("codes after undo %v; want [a]", w.codes)
+	}
+}
+
+func TestUndoLevels(t *testing.T) {
+	w := &Workspace{}
+	for i := 0; i < undoLevels+10; i++ {
+		w.codes = append(w.codes, i)
+		w.record()
+	}
+	n := 0
+	for cmdUndo(w, "") == nil {
+		n++
+	}
+	if n != undoLevels {
+		t.Errorf("%d changes undone; want %d", n, undoLevels)
+	}
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+func firstNonBlank(line []rune) (col int) {
+	for col < len(line) && unicode.IsSpace(line[col]) {
+		col++
+	}
+	return
+}
+
+// wordNext returns the start of the word following col, as vi's w moves
+func wordNext(line []rune, col int) int {
+	if col < len(line) && isWordRune(line[col]) {
+		for col < len(line) && isWordRune(line[col]) {
+			col++
+		}
+	} else {
+		for col < len(line) && !isWordRune(line[col]) && !unicode.IsSpace(line[col]) {
+			col++
+		}
+	}
+	for col < len(line) && unicode.IsSpace(line[col]) {
+		col++
+	}
+	return col
+}
+
+// fixCol keeps the cursor within its line, and in vi's normal mode on a
+// character of it
+func (e *editor) fixCol() {
+	n := len(e.lines[e.row])
+	if e.normal && n > 0 {
+		n--
+	}
+	if e.col > n {
+		e.col = n
+	}
+}
+
+// viMotion returns the column a motion of vi's normal mode moves the
+// cursor to, within its line. For e it is the column after the end of the
+// word, as an operator takes it.
+func (e *editor) viMotion(key string) (col int, ok bool) {
+	line := e.lines[e.row]
+	switch key {
+	case "h", "left", "backspace", "C-h":
+		if col = e.col - 1; col < 0 {
+			col = 0
+		}
+	case "l", "right", " ":
+		if col = e.col + 1; col > len(line) {
+			col = len(line)
+		}
+	case "0", "home":
+		col = 0
+	case "^":
+		col = firstNonBlank(line)
+	case "$", "end":
+		col = len(line)
+	case "w":
+		col = wordNext(line, e.col)
+	case "e":
+		col = wordEnd(line, e.col+1)
+	case "b":
+		col = wordStart(line, e.col)
+	default:
+		return 0, false
+	}
+	return clampCol(line, col), true
+}
+
+func clampCol(line []rune, col int) int {
+	if col < 0 {
+		return 0
+	}
+	if col > len(line) {
+		return len(line)
+	}
+	return col
+}
+
+// viCommand runs key as a command of vi's normal mode
+func (e *editor) viCommand(key string) (done bool, err error) {
+	e.typing = false
+	line := e.lines[e.row]
+	switch key {
+	case "j", "down", "+":
+		return e.do("next-line")
+	case "k", "up", "-":
+		return e.do("previous-line")
+	case "e":
+		if col := wordEnd(line, e.col+1); col > e.col {
+			e.col = col - 1
+		}
+	case "G":
+		e.row = len(e.lines) - 1
+	case "x", "delete":
+		if len(line) > 0 {
+			e.save()
+			e.kill(e.col, e.col+1)
+		}
+	case "X":
+		if e.col > 0 {
+			e.save()
+			e.kill(e.col-1, e.col)
+		}
+	case "D", "C":
+		e.save()
+		e.kill(e.col, len(line))
+		e.normal = key == "D"
+	case "S":
+		e.save()
+		e.kill(0, len(line))
+		e.normal = false
+	case "d", "c", "y":
+		e.viOperator(key)
+	case "p", "P":
+		e.viPut(key == "p")
+	case "r":
+		key, err := e.readKey()
+		if err != nil {
+			return false, err
+		}
+		if r := []rune(key); len(r) == 1 && unicode.IsPrint(r[0]) && e.col < len(line) {
+			e.save()
+			line[e.col] = r[0]
+		}
+	case "~":
+		if e.col < len(line) {
+			e.save()
+			if r := line[e.col]; unicode.IsUpper(r) {
+				line[e.col] = unicode.ToLower(r)
+			} else {
+				line[e.col] = unicode.ToUpper(r)
+			}
+			e.col++
+		}
+	case "i":
+		e.normal = false
+	case "a":
+		if len(line) > 0 {
+			e.col++
+		}
+		e.normal = false
+	case "I":
+		e.col = firstNonBlank(line)
+		e.normal = false
+	case "A":
+		e.col = len(line)
+		e.normal = false
+	case "o":
+		e.save()
+		e.col = len(line)
+		e.insert([]rune{'\n'})
+		e.normal = false
+	case "O":
+		e.save()
+		e.col = 0
+		e.insert([]rune{'\n'})
+		e.row--
+		e.normal = false
+	case "u":
+		e.restore()
+	case "enter":
+		return e.do("accept-line")
+	case "/", "?":
+		return e.do("search-history")
+	case "esc":
+	default:
+		if col, ok := e.viMotion(key); ok {
+			e.col = col
+		} else if action, ok := emacsKeys[key]; ok && strings.HasPrefix(key, "C-") {
+			return e.do(action)
+		} else {
+			e.bell()
+		}
+	}
+	e.fixCol()
+	return
+}
+
+// viOperator applies d, c or y to the text the following motion moves
+// over, or to the whole line when it is repeated, as in dd
+func (e *editor) viOperator(op string) {
+	key, err := e.readKey()
+	if err != nil {
+		return
+	}
+	line := e.lines[e.row]
+
+	if key == op {
+		e.killed, e.killRow = append([]rune(nil), line...), true
+		if op == "y" {
+			return
+		}
+		e.save()
+		switch {
+		case op == "c" || len(e.lines) == 1:
+			e.lines[e.row] = nil
+			e.col = 0
+		default:
+			e.lines = append(e.lines[:e.row], e.lines[e.row+1:]...)
+			if e.row == len(e.lines) {
+				e.row--
+			}
+			e.col = firstNonBlank(e.lines[e.row])
+		}
+		e.normal = op != "c"
+		return
+	}
+
+	col, ok := e.viMotion(key)
+	// cw changes up to the end of the word under the cursor, as vi does
+	if op == "c" && key == "w" && e.col < len(line) && !unicode.IsSpace(line[e.col]) {
+		word := isWordRune(line[e.col])
+		for col = e.col; col < len(line) && !unicode.IsSpace(line[col]) && isWordRune(line[col]) == word; col++ {
+		}
+	}
+	if !ok {
+		e.bell()
+		return
+	}
+	from, to := clampCol(line, e.col), clampCol(line, col)
+	if from > to {
+		from, to = to, from
+	}
+	if op == "y" {
+		e.killed, e.killRow = append([]rune(nil), line[from:to]...), false
+		return
+	}
+	e.save()
+	e.kill(from, to)
+	e.normal = op != "c"
+}
+
+// viPut puts the text last deleted or yanked after or before the cursor,
+// or the line after or below the current one
+func (e *editor) viPut(after bool) {
+	if len(e.killed) == 0 && !e.killRow {
+		return
+	}
+	e.save()
+	if e.killRow {
+		row := e.row
+		if after {
+			row++
+		}
+		killed := append([]rune(nil), e.killed...)
+		e.lines = append(e.lines[:row], append([][]rune{killed}, e.lines[row:]...)...)
+		e.row, e.col = row, firstNonBlank(killed)
+		return
+	}
+	if after && len(e.lines[e.row]) > 0 {
+		e.col++
+	}
+	e.insert(e.killed)
+	e.col--
+}
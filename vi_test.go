@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"io/ioutil"
+	"testing"
+)
+
+// viEditor returns an editor in vi's normal mode on line, with the cursor
+// at col and keys waiting to be read
+func viEditor(line string, col int, keys string) *editor {
+	e := &editor{fd: -1, out: bufio.NewWriter(ioutil.Discard), runes: make(chan rune, len(keys))}
+	for _, r := range keys {
+		e.runes <- r
+	}
+	e.lines, e.col, e.normal = [][]rune{[]rune(line)}, col, true
+	return e
+}
+
+func TestViMotion(t *testing.T) {
+	for _, c := range []struct {
+		line string
+		col  int
+		key  string
+		want int
+	}{
+		{"", 0, "h", 0},
+		{"", 0, "l", 0},
+		{"", 0, "$", 0},
+		{"", 0, "^", 0},
+		{"", 0, "w", 0},
+		{"", 0, "e", 0},
+		{"", 0, "b", 0},
+		{"a", 0, "h", 0},
+		{"a", 0, "l", 1},
+		{"a", 0, "$", 1},
+		{"a", 0, "w", 1},
+		{"a", 0, "e", 1},
+		{"a", 0, "b", 0},
+		{" ", 0, "e", 1},
+		{"ab cd", 0, "w", 3},
+		{"ab cd", 0, "e", 2},
+		{"ab cd", 1, "e", 5},
+		{"ab cd", 4, "b", 3},
+	} {
+		e := viEditor(c.line, c.col, "")
+		if col, ok := e.viMotion(c.key); !ok || col != c.want {
+			t.Errorf("%q at %d: motion %s = %d, %v; want %d", c.line, c.col, c.key, col, ok, c.want)
+		}
+	}
+}
+
+func TestViOperator(t *testing.T) {
+	for _, c := range []struct {
+		line   string
+		col    int
+		keys   string
+		want   string
+		killed string
+	}{
+		{"", 0, "de", "", ""},
+		{"", 0, "ce", "", ""},
+		{"", 0, "ye", "", ""},
+		{"", 0, "dw", "", ""},
+		{"", 0, "d$", "", ""},
+		{"", 0, "dl", "", ""},
+		{"", 0, "cw", "", ""},
+		{"", 0, "dd", "", ""},
+		{"a", 0, "de", "", "a"},
+		{"a", 0, "ce", "", "a"},
+		{"a", 0, "ye", "a", "a"},
+		{"a", 0, "dw", "", "a"},
+		{"a", 0, "dh", "a", ""},
+		{"a", 0, "cw", "", "a"},
+		{"a", 0, "db", "a", ""},
+		{"a", 1, "de", "a", ""},
+		{"ab cd", 0, "de", " cd", "ab"},
+		{"ab cd", 0, "dw", "cd", "ab "},
+		{"ab cd", 0, "cw", " cd", "ab"},
+		{"ab cd", 4, "db", "ab d", "c"},
+	} {
+		e := viEditor(c.line, c.col, c.keys[1:])
+		if _, err := e.viCommand(c.keys[:1]); err != nil {
+			t.Errorf("%q at %d: %s: %v", c.line, c.col, c.keys, err)
+			continue
+		}
+		if line := string(e.lines[0]); line != c.want || string(e.killed) != c.killed {
+			t.Errorf("%q at %d: %s gives %q, killed %q; want %q, killed %q",
+				c.line, c.col, c.keys, line, string(e.killed), c.want, c.killed)
+		}
+	}
+}